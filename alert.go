@@ -0,0 +1,41 @@
+// Send alerts about failures that need manual intervention.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Log an alert and post it to the configured webhook, if any. The webhook receives a JSON object
+// with subject, message and time fields.
+func SendAlert(subject string, message string) {
+	log.Printf("ALERT: %s: %s", subject, message)
+	if config.AlertWebhookURL == "" {
+		return
+	}
+
+	body, err := json.Marshal(map[string]string{
+		"subject": subject,
+		"message": message,
+		"time":    time.Now().Format(time.RFC3339),
+	})
+	if err != nil {
+		log.Printf("Failed to encode alert: %v", err)
+		return
+	}
+	go func() {
+		client := http.Client{Timeout: alertTimeout}
+		resp, err := client.Post(config.AlertWebhookURL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("Failed to send alert: %v", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Printf("Failed to send alert: webhook returned %s", resp.Status)
+		}
+	}()
+}
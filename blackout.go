@@ -0,0 +1,191 @@
+// Keep scheduled jobs out of configured blackout windows.
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Skip a scheduled job that falls inside a blackout window.
+	BlackoutPolicySkip = "skip"
+	// Run a scheduled job that falls inside a blackout window once the window ends.
+	BlackoutPolicyDefer = "defer"
+)
+
+// A blackout window is either recurring (weekdays with a start and end time of day)
+// or one-off (an absolute from and to time).
+type BlackoutWindow struct {
+	// Recurring: days of week the window starts on, e.g. ["sat", "sun"]. Empty means every day.
+	Weekdays []string `json:"weekdays,omitempty"`
+	// Recurring: start time of day in local time, "HH:MM".
+	Start string `json:"start,omitempty"`
+	// Recurring: end time of day in local time, "HH:MM". An end before start spans midnight.
+	End string `json:"end,omitempty"`
+	// One-off: start time in RFC 3339 format.
+	From string `json:"from,omitempty"`
+	// One-off: end time in RFC 3339 format.
+	To string `json:"to,omitempty"`
+	// Optional description, used in logs.
+	Reason string `json:"reason,omitempty"`
+
+	weekdays    map[time.Weekday]bool
+	startMinute int
+	endMinute   int
+	from        time.Time
+	to          time.Time
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+func parseTimeOfDay(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Parse and validate the textual fields of a blackout window.
+func (w *BlackoutWindow) parse() error {
+	var err error
+	if w.From != "" || w.To != "" {
+		if w.Start != "" || w.End != "" || len(w.Weekdays) > 0 {
+			return fmt.Errorf("a window cannot be both one-off (from/to) and recurring (weekdays/start/end)")
+		}
+		w.from, err = time.Parse(time.RFC3339, w.From)
+		if err != nil {
+			return fmt.Errorf("invalid from: %v", err)
+		}
+		w.to, err = time.Parse(time.RFC3339, w.To)
+		if err != nil {
+			return fmt.Errorf("invalid to: %v", err)
+		}
+		if !w.to.After(w.from) {
+			return fmt.Errorf("to must be after from")
+		}
+		return nil
+	}
+
+	w.startMinute, err = parseTimeOfDay(w.Start)
+	if err != nil {
+		return fmt.Errorf("invalid start: %v", err)
+	}
+	w.endMinute, err = parseTimeOfDay(w.End)
+	if err != nil {
+		return fmt.Errorf("invalid end: %v", err)
+	}
+	if w.startMinute == w.endMinute {
+		return fmt.Errorf("start and end must differ")
+	}
+	w.weekdays = make(map[time.Weekday]bool)
+	for _, name := range w.Weekdays {
+		day, ok := weekdayNames[strings.ToLower(name)[:min(3, len(name))]]
+		if !ok {
+			return fmt.Errorf("invalid weekday %q", name)
+		}
+		w.weekdays[day] = true
+	}
+	return nil
+}
+
+// Return the end of this window if t falls inside it.
+func (w *BlackoutWindow) activeAt(t time.Time) (time.Time, bool) {
+	if !w.from.IsZero() {
+		if !t.Before(w.from) && t.Before(w.to) {
+			return w.to, true
+		}
+		return time.Time{}, false
+	}
+
+	// A recurring window may have started today or, when it spans midnight, yesterday.
+	today := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	for _, day := range []time.Time{today, today.AddDate(0, 0, -1)} {
+		if len(w.weekdays) > 0 && !w.weekdays[day.Weekday()] {
+			continue
+		}
+		start := day.Add(time.Duration(w.startMinute) * time.Minute)
+		end := day.Add(time.Duration(w.endMinute) * time.Minute)
+		if w.endMinute < w.startMinute {
+			end = end.AddDate(0, 0, 1)
+		}
+		if !t.Before(start) && t.Before(end) {
+			return end, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Report whether t falls inside any blackout window, and when the blackout ends.
+// Overlapping and adjacent windows are merged, up to maxBlackoutExtension after t.
+func InBlackout(t time.Time) (BlackoutWindow, time.Time, bool) {
+	var window BlackoutWindow
+	end := t
+	active := false
+	for {
+		extended := false
+		for _, w := range config.BlackoutWindows {
+			windowEnd, ok := w.activeAt(end)
+			if ok && windowEnd.After(end) {
+				if !active {
+					window = w
+				}
+				end = windowEnd
+				active = true
+				extended = true
+			}
+		}
+		if end.Sub(t) >= maxBlackoutExtension {
+			return window, t.Add(maxBlackoutExtension), true
+		}
+		if !extended {
+			return window, end, active
+		}
+	}
+}
+
+var (
+	deferredJobsMu sync.Mutex
+	deferredJobs   = make(map[string]bool)
+)
+
+// Check whether a scheduled job may run now. If it falls inside a blackout window,
+// it is either skipped or deferred to the end of the window according to the configured policy,
+// and false is returned.
+func allowedOutsideBlackout(name string, run func()) bool {
+	window, end, active := InBlackout(time.Now())
+	if !active {
+		return true
+	}
+	if config.BlackoutPolicy == BlackoutPolicySkip {
+		log.Printf("Skipped scheduled %s, blackout window %q is active until %s", name, window.Reason, end.Format(time.DateTime))
+		return false
+	}
+
+	deferredJobsMu.Lock()
+	defer deferredJobsMu.Unlock()
+	if deferredJobs[name] {
+		log.Printf("Scheduled %s is already deferred to the end of the blackout window.", name)
+		return false
+	}
+	deferredJobs[name] = true
+	log.Printf("Deferred scheduled %s to %s, blackout window %q is active", name, end.Format(time.DateTime), window.Reason)
+	time.AfterFunc(time.Until(end), func() {
+		deferredJobsMu.Lock()
+		delete(deferredJobs, name)
+		deferredJobsMu.Unlock()
+		run()
+	})
+	return false
+}
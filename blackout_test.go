@@ -0,0 +1,125 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func parseBlackoutTestWindows(t *testing.T, windows ...BlackoutWindow) []BlackoutWindow {
+	t.Helper()
+	for i := range windows {
+		err := windows[i].parse()
+		if err != nil {
+			t.Fatalf("parse window %d: %v", i, err)
+		}
+	}
+	return windows
+}
+
+// 2025-12-01 is a Monday.
+func blackoutTestTime(day int, hour int, minute int) time.Time {
+	return time.Date(2025, 12, day, hour, minute, 0, 0, time.UTC)
+}
+
+func TestBlackoutWindowActiveAt(t *testing.T) {
+	windows := parseBlackoutTestWindows(t,
+		BlackoutWindow{Start: "22:00", End: "06:00"},
+		BlackoutWindow{Weekdays: []string{"sat"}, Start: "22:00", End: "06:00"},
+		BlackoutWindow{From: "2025-12-01T10:00:00Z", To: "2025-12-01T12:00:00Z"},
+	)
+	overnight, saturdayNight, oneOff := windows[0], windows[1], windows[2]
+	tests := []struct {
+		name   string
+		window BlackoutWindow
+		t      time.Time
+		want   time.Time
+		active bool
+	}{
+		{"after midnight", overnight, blackoutTestTime(1, 1, 0), blackoutTestTime(1, 6, 0), true},
+		{"before midnight", overnight, blackoutTestTime(1, 23, 0), blackoutTestTime(2, 6, 0), true},
+		{"at start", overnight, blackoutTestTime(1, 22, 0), blackoutTestTime(2, 6, 0), true},
+		{"at end", overnight, blackoutTestTime(1, 6, 0), time.Time{}, false},
+		{"just before start", overnight, blackoutTestTime(1, 21, 59), time.Time{}, false},
+		{"weekday, started yesterday", saturdayNight, blackoutTestTime(7, 3, 0), blackoutTestTime(7, 6, 0), true},
+		{"weekday, started today", saturdayNight, blackoutTestTime(6, 23, 0), blackoutTestTime(7, 6, 0), true},
+		{"weekday, started on another day", saturdayNight, blackoutTestTime(6, 3, 0), time.Time{}, false},
+		{"weekday, other day", saturdayNight, blackoutTestTime(7, 23, 0), time.Time{}, false},
+		{"one-off, at from", oneOff, blackoutTestTime(1, 10, 0), blackoutTestTime(1, 12, 0), true},
+		{"one-off, at to", oneOff, blackoutTestTime(1, 12, 0), time.Time{}, false},
+	}
+	for _, test := range tests {
+		got, active := test.window.activeAt(test.t)
+		if active != test.active || !got.Equal(test.want) {
+			t.Errorf("%s: activeAt(%s) = %s, %v, want %s, %v", test.name, test.t, got, active, test.want, test.active)
+		}
+	}
+}
+
+func TestInBlackout(t *testing.T) {
+	defer func(windows []BlackoutWindow) { config.BlackoutWindows = windows }(config.BlackoutWindows)
+
+	tests := []struct {
+		name    string
+		windows []BlackoutWindow
+		t       time.Time
+		want    time.Time
+		active  bool
+		reason  string
+	}{
+		{
+			name:    "outside",
+			windows: []BlackoutWindow{{Start: "22:00", End: "06:00", Reason: "night"}},
+			t:       blackoutTestTime(1, 6, 0),
+			want:    blackoutTestTime(1, 6, 0),
+		},
+		{
+			name:    "spanning midnight",
+			windows: []BlackoutWindow{{Start: "22:00", End: "06:00", Reason: "night"}},
+			t:       blackoutTestTime(1, 23, 30),
+			want:    blackoutTestTime(2, 6, 0),
+			active:  true,
+			reason:  "night",
+		},
+		{
+			name: "adjacent",
+			windows: []BlackoutWindow{
+				{Start: "22:00", End: "02:00", Reason: "night"},
+				{Start: "02:00", End: "04:00", Reason: "maintenance"},
+			},
+			t:      blackoutTestTime(1, 23, 0),
+			want:   blackoutTestTime(2, 4, 0),
+			active: true,
+			reason: "night",
+		},
+		{
+			name: "overlapping",
+			windows: []BlackoutWindow{
+				{Start: "02:30", End: "05:00", Reason: "maintenance"},
+				{Start: "01:00", End: "03:00", Reason: "night"},
+			},
+			t:      blackoutTestTime(1, 1, 30),
+			want:   blackoutTestTime(1, 5, 0),
+			active: true,
+			reason: "night",
+		},
+		{
+			name: "all day",
+			windows: []BlackoutWindow{
+				{Start: "00:00", End: "12:00", Reason: "morning"},
+				{Start: "12:00", End: "00:00", Reason: "afternoon"},
+			},
+			t:      blackoutTestTime(1, 9, 0),
+			want:   blackoutTestTime(1, 9, 0).Add(maxBlackoutExtension),
+			active: true,
+			reason: "morning",
+		},
+	}
+	for _, test := range tests {
+		config.BlackoutWindows = parseBlackoutTestWindows(t, test.windows...)
+		window, end, active := InBlackout(test.t)
+		if active != test.active || !end.Equal(test.want) || window.Reason != test.reason {
+			t.Errorf("%s: InBlackout(%s) = %q, %s, %v, want %q, %s, %v",
+				test.name, test.t, window.Reason, end, active, test.reason, test.want, test.active)
+		}
+	}
+}
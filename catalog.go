@@ -0,0 +1,160 @@
+// Describe tracked backups for the catalog API.
+package main
+
+import (
+	"cmp"
+	"database/sql"
+	"maps"
+	"slices"
+	"time"
+)
+
+// A tracked backup as listed by the catalog.
+type BackupSummary struct {
+	ID         int64     `json:"id"`
+	Target     string    `json:"target"`
+	Name       string    `json:"name"`
+	Type       string    `json:"type"`
+	Status     Status    `json:"status"`
+	BackupTime time.Time `json:"backup_time"`
+	Comment    string    `json:"comment,omitempty"`
+	// The remote object of a streamed backup, e.g. "onedrive:/mysql/db_20251130_1200.xbstream".
+	RemoteObject    string `json:"remote_object,omitempty"`
+	EncryptionKeyID string `json:"encryption_key_id,omitempty"`
+	// Whether the backup is encrypted with a key that must be given on download.
+	EncryptionKeyUnknown bool   `json:"encryption_key_unknown,omitempty"`
+	Compression          string `json:"compression,omitempty"`
+	// The total size and number of files of the backup, zero for backups tracked before manifests.
+	Size  int64 `json:"size,omitempty"`
+	Files int   `json:"files,omitempty"`
+}
+
+// The last scrub outcome of a copy of a backup.
+type ScrubStatus struct {
+	VerifiedAt time.Time `json:"verified_at"`
+	Outcome    string    `json:"outcome"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// The copy of a backup on a remote: its latest upload, or the streamed object.
+type BackupRemoteStatus struct {
+	Remote string `json:"remote"`
+	Path   string `json:"path"`
+	// The state of the latest upload to the remote; streamed backups are done unless remote retention
+	// deleted them.
+	State     UploadState  `json:"state"`
+	Attempts  int          `json:"attempts,omitempty"`
+	LastError string       `json:"last_error,omitempty"`
+	UpdatedAt *time.Time   `json:"updated_at,omitempty"`
+	Scrub     *ScrubStatus `json:"scrub,omitempty"`
+}
+
+// The full record of a tracked backup.
+type BackupDetail struct {
+	BackupSummary
+	Binlog   *BinlogInfo `json:"binlog,omitempty"`
+	Manifest Manifest    `json:"manifest,omitempty"`
+	// The backup an incremental backup is based on, and the incremental backup based on this one.
+	Parent   *BackupSummary  `json:"parent,omitempty"`
+	Children []BackupSummary `json:"children"`
+	// The local backup directory, empty once the backup is archived or if it was streamed.
+	LocalPath  string               `json:"local_path,omitempty"`
+	LocalScrub *ScrubStatus         `json:"local_scrub,omitempty"`
+	Remotes    []BackupRemoteStatus `json:"remotes"`
+}
+
+func newBackupSummary(track DatabaseTrack) BackupSummary {
+	summary := BackupSummary{
+		ID:                   track.ID,
+		Target:               track.Target,
+		Name:                 track.BackupName(),
+		Type:                 track.Type,
+		Status:               track.Status,
+		BackupTime:           track.BackupTime,
+		Comment:              track.Comment,
+		RemoteObject:         track.RemoteObject,
+		EncryptionKeyID:      track.EncryptionKeyID,
+		EncryptionKeyUnknown: track.EncryptionKeyUnknown,
+		Compression:          track.Compression,
+		Files:                len(track.Manifest),
+	}
+	for _, entry := range track.Manifest {
+		summary.Size += entry.Size
+	}
+	return summary
+}
+
+// Describe a backup with its chain, local copy and copies on remotes.
+func newBackupDetail(track DatabaseTrack) (BackupDetail, error) {
+	detail := BackupDetail{
+		BackupSummary: newBackupSummary(track),
+		Manifest:      track.Manifest,
+		Children:      []BackupSummary{},
+		Remotes:       []BackupRemoteStatus{},
+	}
+	if track.Binlog != (BinlogInfo{}) {
+		detail.Binlog = &track.Binlog
+	}
+
+	if track.IsIncrementalBackup() {
+		parent, err := tracker.GetParentBackup(track)
+		if err != nil && err != sql.ErrNoRows {
+			return BackupDetail{}, err
+		}
+		if err == nil {
+			summary := newBackupSummary(parent)
+			detail.Parent = &summary
+		}
+	}
+	children, err := tracker.GetChildBackups(track)
+	if err != nil {
+		return BackupDetail{}, err
+	}
+	for _, child := range children {
+		detail.Children = append(detail.Children, newBackupSummary(child))
+	}
+
+	scrubs, err := tracker.GetScrubsOfBackup(track.ID)
+	if err != nil {
+		return BackupDetail{}, err
+	}
+	scrubbed := make(map[string]*ScrubStatus)
+	for _, result := range scrubs {
+		scrubbed[result.Location] = &ScrubStatus{VerifiedAt: result.VerifiedAt, Outcome: result.Outcome, Error: result.Error}
+	}
+	if !track.IsStreamed() && track.Status != Archived {
+		detail.LocalPath = track.GetBackupPath()
+		detail.LocalScrub = scrubbed[scrubLocal]
+	}
+
+	uploads, err := tracker.GetUploadsOfBackup(track.ID)
+	if err != nil {
+		return BackupDetail{}, err
+	}
+	// The latest upload to each remote.
+	latest := make(map[string]Upload)
+	if track.IsStreamed() {
+		remote, object := splitRemoteObject(track.RemoteObject)
+		latest[remote] = Upload{Remote: remote, RemotePath: object, State: UploadDone}
+	}
+	for _, upload := range uploads {
+		latest[cmp.Or(upload.Remote, config.DefaultRCloneRemote)] = upload
+	}
+	for _, remote := range slices.Sorted(maps.Keys(latest)) {
+		upload := latest[remote]
+		status := BackupRemoteStatus{
+			Remote:    remote,
+			Path:      upload.RemotePath,
+			State:     upload.State,
+			Attempts:  upload.Attempts,
+			LastError: upload.LastError,
+			Scrub:     scrubbed[remote],
+		}
+		if upload.ID != 0 {
+			status.Path = upload.RemoteBackupPath()
+			status.UpdatedAt = &upload.UpdatedAt
+		}
+		detail.Remotes = append(detail.Remotes, status)
+	}
+	return detail, nil
+}
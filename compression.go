@@ -0,0 +1,73 @@
+// Compression settings of xtrabackup backups.
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+)
+
+// Compression of a backup.
+type Compression struct {
+	// zstd, lz4 or none.
+	Algorithm string `json:"algorithm"`
+	// The zstd compression level from 1 to 19, zero for the xtrabackup default.
+	Level int `json:"level,omitempty"`
+	// The number of compression threads, zero for the parallel setting.
+	Threads int `json:"threads,omitempty"`
+}
+
+// Compression settings of scheduled backups by type.
+type CompressionConfig struct {
+	Full        Compression `json:"full"`
+	Incremental Compression `json:"incremental"`
+}
+
+var compressionAlgorithms = []string{"zstd", "lz4", "none"}
+
+// Fill in defaults and validate the settings.
+func (c *Compression) normalize() error {
+	if c.Algorithm == "" {
+		c.Algorithm = defaultCompressionAlgorithm
+	}
+	if !slices.Contains(compressionAlgorithms, c.Algorithm) {
+		return fmt.Errorf("unknown compression algorithm %q", c.Algorithm)
+	}
+	if c.Level != 0 && c.Algorithm != "zstd" {
+		return fmt.Errorf("compression level is only supported by zstd")
+	}
+	if c.Level < 0 || c.Level > 19 {
+		return fmt.Errorf("invalid zstd compression level %d", c.Level)
+	}
+	if c.Threads < 0 {
+		return fmt.Errorf("invalid compression thread count %d", c.Threads)
+	}
+	if c.Threads == 0 {
+		c.Threads = config.Parallel
+	}
+	return nil
+}
+
+// xtrabackup arguments for the compression settings.
+func (c Compression) args() []string {
+	if c.Algorithm == "none" {
+		return nil
+	}
+	args := []string{"--compress=" + c.Algorithm, "--compress-threads=" + strconv.Itoa(c.Threads)}
+	if c.Level != 0 {
+		args = append(args, "--compress-zstd-level="+strconv.Itoa(c.Level))
+	}
+	return args
+}
+
+// The compression of a backup of the given type, from override if set or else from the target.
+func resolveCompression(target *Target, backupType string, override *Compression) (Compression, error) {
+	if override != nil {
+		compression := *override
+		return compression, compression.normalize()
+	}
+	if backupType == "incremental" {
+		return target.Compression.Incremental, nil
+	}
+	return target.Compression.Full, nil
+}
@@ -2,6 +2,7 @@
 package main
 
 import (
+	"cmp"
 	"encoding/json"
 	"log"
 	"os"
@@ -11,42 +12,118 @@ import (
 type Config struct {
 	MysqlUser           string `json:"mysql_user"`
 	MysqlPassword       string `json:"mysql_password"`
+	MysqlPasswordFile   string `json:"mysql_password_file"`
 	MysqlHost           string `json:"mysql_host"`
 	MysqlPort           int    `json:"mysql_port"`
+	MysqlSocket         string `json:"mysql_socket"`
+	MysqlDefaultsFile   string `json:"mysql_defaults_file"`
+	MysqlSSLMode        string `json:"mysql_ssl_mode"`
+	MysqlSSLCA          string `json:"mysql_ssl_ca"`
+	MysqlSSLCert        string `json:"mysql_ssl_cert"`
+	MysqlSSLKey         string `json:"mysql_ssl_key"`
 	Parallel            int    `json:"parallel"`
 	LocalBackupCount    int    `json:"local_backup_count"`
 	DefaultRCloneRemote string `json:"default_rclone_remote"`
+	// The number of backup chains kept on each remote, all of them if 0.
+	RemoteBackupCount int `json:"remote_backup_count"`
+
+	// Paths inside the container. Targets store their backups in subdirectories of BackupDir and DownloadDir.
+	Datadir      string `json:"datadir"`
+	BackupDir    string `json:"backup_dir"`
+	DownloadDir  string `json:"download_dir"`
+	DatabasePath string `json:"database_path"`
+	JobLogDir    string `json:"job_log_dir"`
+	RCloneConfig string `json:"rclone_config"`
+	HTTPPort     int    `json:"http_port"`
+
+	// Remotes stored natively or configured by name. Other remotes are remotes of rclone.conf.
+	Remotes []*RemoteConfig `json:"remotes"`
+	// The directory backups are stored in on remotes, a template with the placeholders {target}, {year},
+	// {month} and {type}. Backups are stored at the path of the local backup directory if empty.
+	RemotePath string `json:"remote_path"`
+	// Transfer through the remote control API of a long-running rclone rcd.
+	RCloneRC RCloneRCConfig `json:"rclone_rc"`
 
 	FullBackupIntervalStr        string `json:"full_backup_interval"`
 	IncrementalBackupIntervalStr string `json:"incremental_backup_interval"`
 	CleanupIntervalStr           string `json:"cleanup_interval"`
 	RcloneUploadIntervalStr      string `json:"rclone_upload_interval"`
+	ScrubIntervalStr             string `json:"scrub_interval"`
 
 	FullBackupInterval        time.Duration `json:"-"`
 	IncrementalBackupInterval time.Duration `json:"-"`
 	CleanupInterval           time.Duration `json:"-"`
 	RcloneUploadInterval      time.Duration `json:"-"`
+	ScrubInterval             time.Duration `json:"-"`
+
+	// The fraction of the remote backup copies verified per scrub, least recently verified first.
+	ScrubRemoteFraction float64 `json:"scrub_remote_fraction"`
+
+	FullBackupTimeoutStr        string `json:"full_backup_timeout"`
+	IncrementalBackupTimeoutStr string `json:"incremental_backup_timeout"`
+	UploadTimeoutStr            string `json:"upload_timeout"`
+	DownloadTimeoutStr          string `json:"download_timeout"`
+	ShutdownTimeoutStr          string `json:"shutdown_timeout"`
+	JobLogRetentionStr          string `json:"job_log_retention"`
+
+	FullBackupTimeout        time.Duration `json:"-"`
+	IncrementalBackupTimeout time.Duration `json:"-"`
+	UploadTimeout            time.Duration `json:"-"`
+	DownloadTimeout          time.Duration `json:"-"`
+	ShutdownTimeout          time.Duration `json:"-"`
+	JobLogRetention          time.Duration `json:"-"`
+
+	UploadWorkers      int    `json:"upload_workers"`
+	UploadMaxAttempts  int    `json:"upload_max_attempts"`
+	UploadRetryBaseStr string `json:"upload_retry_base"`
+	UploadRetryMaxStr  string `json:"upload_retry_max"`
+	AlertWebhookURL    string `json:"alert_webhook_url"`
+
+	UploadRetryBase time.Duration `json:"-"`
+	UploadRetryMax  time.Duration `json:"-"`
+
+	// How uploaded backups are verified against their manifest: checksum, size or off.
+	UploadVerification string `json:"upload_verification"`
+
+	BlackoutWindows []BlackoutWindow `json:"blackout_windows"`
+	BlackoutPolicy  string           `json:"blackout_policy"`
+
+	// Stream backups to the remote instead of staging them in the backup directory.
+	StreamBackups bool `json:"stream_backups"`
+
+	Encryption  EncryptionConfig  `json:"encryption"`
+	Compression CompressionConfig `json:"compression"`
+	Replica     ReplicaConfig     `json:"replica"`
+	Throttle    ThrottleConfig    `json:"throttle"`
+
+	// The MySQL instances to back up. The top-level settings define a single target if omitted.
+	Targets []*Target `json:"targets"`
+
+	Hooks []*Hook `json:"hooks"`
 }
 
 var config Config
 
 func InitializeConfig() {
+	configFileName := cmp.Or(os.Getenv(configFileEnv), defaultConfigFile)
 	configFile, err := os.ReadFile(configFileName)
 	if err != nil {
-		log.Fatalln("Failed to read config.json from current directory:", err)
+		log.Fatalf("Failed to read %s: %v", configFileName, err)
 	}
 
 	err = json.Unmarshal(configFile, &config)
 	if err != nil {
-		log.Fatalln("Failed to parse config.json:", err)
+		log.Fatalf("Failed to parse %s: %v", configFileName, err)
+	}
+
+	err = applyEnvOverrides()
+	if err != nil {
+		log.Fatalf("Invalid environment: %v", err)
 	}
 
 	if config.MysqlUser == "" {
 		config.MysqlUser = defaultMysqlUser
 	}
-	if config.MysqlPassword == "" {
-		config.MysqlPassword = defaultMysqlPassword
-	}
 	if config.MysqlHost == "" {
 		config.MysqlHost = defaultMysqlHost
 	}
@@ -62,40 +139,135 @@ func InitializeConfig() {
 	if config.DefaultRCloneRemote == "" {
 		config.DefaultRCloneRemote = defaultRCloneRemote
 	}
+	if config.RemoteBackupCount < 0 {
+		log.Fatalf("Invalid remote_backup_count %d, must not be negative", config.RemoteBackupCount)
+	}
 
-	if config.FullBackupIntervalStr != "" {
-		config.FullBackupInterval, err = time.ParseDuration(config.FullBackupIntervalStr)
-		if err != nil {
-			log.Fatalf("Invalid full_backup_interval: %v", err)
-		}
-	} else {
-		config.FullBackupInterval = defaultFullBackupInterval
+	err = initializePaths()
+	if err != nil {
+		log.Fatalf("Invalid paths: %v", err)
 	}
 
-	if config.IncrementalBackupIntervalStr != "" {
-		config.IncrementalBackupInterval, err = time.ParseDuration(config.IncrementalBackupIntervalStr)
-		if err != nil {
-			log.Fatalf("Invalid incremental_backup_interval: %v", err)
-		}
-	} else {
-		config.IncrementalBackupInterval = defaultIncrementalBackupInterval
+	config.FullBackupInterval = parseInterval("full_backup_interval", config.FullBackupIntervalStr, defaultFullBackupInterval)
+	config.IncrementalBackupInterval = parseInterval("incremental_backup_interval", config.IncrementalBackupIntervalStr, defaultIncrementalBackupInterval)
+	config.CleanupInterval = parseInterval("cleanup_interval", config.CleanupIntervalStr, defaultCleanupInterval)
+	config.RcloneUploadInterval = parseInterval("rclone_upload_interval", config.RcloneUploadIntervalStr, defaultRcloneUploadInterval)
+	config.ScrubInterval = parseInterval("scrub_interval", config.ScrubIntervalStr, defaultScrubInterval)
+	if config.ScrubRemoteFraction == 0 {
+		config.ScrubRemoteFraction = defaultScrubRemoteFraction
+	}
+	if config.ScrubRemoteFraction < 0 || config.ScrubRemoteFraction > 1 {
+		log.Fatalf("Invalid scrub_remote_fraction: %v, must be between 0 and 1", config.ScrubRemoteFraction)
 	}
 
-	if config.CleanupIntervalStr != "" {
-		config.CleanupInterval, err = time.ParseDuration(config.CleanupIntervalStr)
-		if err != nil {
-			log.Fatalf("Invalid cleanup_interval: %v", err)
+	config.FullBackupTimeout = parseTimeout("full_backup_timeout", config.FullBackupTimeoutStr, defaultFullBackupTimeout)
+	config.IncrementalBackupTimeout = parseTimeout("incremental_backup_timeout", config.IncrementalBackupTimeoutStr, defaultIncrementalBackupTimeout)
+	config.UploadTimeout = parseTimeout("upload_timeout", config.UploadTimeoutStr, defaultUploadTimeout)
+	config.DownloadTimeout = parseTimeout("download_timeout", config.DownloadTimeoutStr, defaultDownloadTimeout)
+	config.ShutdownTimeout = parseTimeout("shutdown_timeout", config.ShutdownTimeoutStr, defaultShutdownTimeout)
+
+	if config.JobLogRetentionStr != "" {
+		config.JobLogRetention, err = time.ParseDuration(config.JobLogRetentionStr)
+		if err != nil || config.JobLogRetention <= 0 {
+			log.Fatalf("Invalid job_log_retention: %q", config.JobLogRetentionStr)
 		}
 	} else {
-		config.CleanupInterval = defaultCleanupInterval
+		config.JobLogRetention = defaultJobLogRetention
+	}
+
+	if config.UploadWorkers <= 0 {
+		config.UploadWorkers = defaultUploadWorkers
+	}
+	if config.UploadMaxAttempts <= 0 {
+		config.UploadMaxAttempts = defaultUploadMaxAttempts
+	}
+	config.UploadRetryBase = parseTimeout("upload_retry_base", config.UploadRetryBaseStr, defaultUploadRetryBase)
+	config.UploadRetryMax = parseTimeout("upload_retry_max", config.UploadRetryMaxStr, defaultUploadRetryMax)
+	if config.UploadRetryBase <= 0 || config.UploadRetryMax < config.UploadRetryBase {
+		log.Fatalf("Invalid upload retry delays: upload_retry_base must be positive and not exceed upload_retry_max")
+	}
+	switch config.UploadVerification {
+	case "":
+		config.UploadVerification = VerifyChecksum
+	case VerifyChecksum, VerifySize, VerifyOff:
+	default:
+		log.Fatalf("Invalid upload_verification: %q", config.UploadVerification)
 	}
 
-	if config.RcloneUploadIntervalStr != "" {
-		config.RcloneUploadInterval, err = time.ParseDuration(config.RcloneUploadIntervalStr)
+	err = config.Compression.Full.normalize()
+	if err != nil {
+		log.Fatalf("Invalid full backup compression: %v", err)
+	}
+	err = config.Compression.Incremental.normalize()
+	if err != nil {
+		log.Fatalf("Invalid incremental backup compression: %v", err)
+	}
+
+	err = config.Encryption.validate()
+	if err != nil {
+		log.Fatalf("Invalid encryption config: %v", err)
+	}
+
+	err = config.Throttle.validate()
+	if err != nil {
+		log.Fatalf("Invalid throttle config: %v", err)
+	}
+
+	err = initializeRemotes()
+	if err != nil {
+		log.Fatalf("Invalid remotes: %v", err)
+	}
+
+	err = config.Replica.normalize()
+	if err != nil {
+		log.Fatalf("Invalid replica config: %v", err)
+	}
+
+	err = initializeTargets()
+	if err != nil {
+		log.Fatalf("Invalid targets: %v", err)
+	}
+
+	err = validateHooks()
+	if err != nil {
+		log.Fatalf("Invalid hooks: %v", err)
+	}
+
+	for i := range config.BlackoutWindows {
+		err = config.BlackoutWindows[i].parse()
 		if err != nil {
-			log.Fatalf("Invalid rclone_upload_interval: %v", err)
+			log.Fatalf("Invalid blackout_windows[%d]: %v", i, err)
 		}
-	} else {
-		config.RcloneUploadInterval = defaultRcloneUploadInterval
 	}
+	switch config.BlackoutPolicy {
+	case "":
+		config.BlackoutPolicy = defaultBlackoutPolicy
+	case BlackoutPolicySkip, BlackoutPolicyDefer:
+	default:
+		log.Fatalf("Invalid blackout_policy: %q, expected %q or %q", config.BlackoutPolicy, BlackoutPolicySkip, BlackoutPolicyDefer)
+	}
+}
+
+// Parse the interval of a scheduled job. An empty value selects the default.
+func parseInterval(name string, value string, defaultValue time.Duration) time.Duration {
+	if value == "" {
+		return defaultValue
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil || interval <= 0 {
+		log.Fatalf("Invalid %s: %q, must be a positive duration", name, value)
+	}
+	return interval
+}
+
+// Parse an operation timeout. An empty value selects the default, "0" disables the timeout.
+func parseTimeout(name string, value string, defaultValue time.Duration) time.Duration {
+	if value == "" {
+		return defaultValue
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < 0 {
+		log.Fatalf("Invalid %s: %q", name, value)
+	}
+	return timeout
 }
@@ -5,22 +5,83 @@ import "time"
 
 const (
 	defaultMysqlUser        = "backup"
-	defaultMysqlPassword    = "password"
 	defaultMysqlHost        = "localhost"
 	defaultMysqlPort        = 3306
 	defaultParallel         = 4
 	defaultLocalBackupCount = 3
 	defaultRCloneRemote     = "onedrive:"
+	defaultTargetName       = "default"
 
-	configFileName       = "config.json"
-	sqliteDBPath         = "/data/data.db"
-	backupPath           = "/backup/"
-	downloadedBackupPath = "/downloaded_backup/"
+	// The placeholder password of the example config, refused at startup.
+	insecureMysqlPassword = "password"
 
-	HttpPort = 32400
+	defaultConfigFile    = "config.json"
+	configFileEnv        = "CONFIG_FILE"
+	mysqlPasswordEnv     = "MYSQL_PASSWORD"
+	mysqlPasswordFileEnv = "MYSQL_PASSWORD_FILE"
+	defaultDatadir       = "/var/lib/mysql"
+	defaultDatabasePath  = "/data/data.db"
+	defaultBackupDir     = "/backup/"
+	defaultDownloadDir   = "/downloaded_backup/"
+	defaultJobLogDir     = "/data/logs/"
+	defaultRCloneConfig  = "rclone.conf"
+	quarantineDirName    = "quarantine"
+	xbstreamSuffix       = ".xbstream"
+	xbcryptSuffix        = ".xbcrypt"
+	encryptionKeySize    = 32
+
+	defaultCompressionAlgorithm = "zstd"
+
+	defaultHTTPPort = 32400
 
 	defaultFullBackupInterval        = 12 * time.Hour
 	defaultIncrementalBackupInterval = 30 * time.Minute
 	defaultCleanupInterval           = 1 * time.Hour
 	defaultRcloneUploadInterval      = 15 * time.Minute
+	defaultScrubInterval             = 24 * time.Hour
+	defaultScrubRemoteFraction       = 0.1
+
+	defaultBlackoutPolicy = BlackoutPolicyDefer
+	// How far merging overlapping blackout windows may extend a blackout, so that recurring windows
+	// covering the whole day do not extend it forever.
+	maxBlackoutExtension = 7 * 24 * time.Hour
+
+	defaultFullBackupTimeout        = 6 * time.Hour
+	defaultIncrementalBackupTimeout = 2 * time.Hour
+	defaultUploadTimeout            = 6 * time.Hour
+	defaultDownloadTimeout          = 6 * time.Hour
+	subprocessKillDelay             = 30 * time.Second
+	subprocessOutputTailLines       = 20
+	subprocessOutputTailBytes       = 16 * 1024
+	defaultJobLogRetention          = 30 * 24 * time.Hour
+	jobLogFollowInterval            = time.Second
+	progressReportInterval          = time.Second
+	defaultJobListLimit             = 50
+
+	defaultUploadWorkers     = 2
+	defaultUploadMaxAttempts = 8
+	defaultUploadRetryBase   = 1 * time.Minute
+	defaultUploadRetryMax    = 1 * time.Hour
+	uploadDispatchInterval   = 30 * time.Second
+	alertTimeout             = 10 * time.Second
+	defaultHookTimeout       = 5 * time.Minute
+	defaultMaxReplicationLag = 5 * time.Minute
+	defaultShutdownTimeout   = 10 * time.Minute
+	remoteCleanupTimeout     = 5 * time.Minute
+	remoteListTimeout        = 2 * time.Minute
+	catalogRebuildTimeout    = 10 * time.Minute
+
+	defaultS3Region    = "us-east-1"
+	s3MinPartSize      = 16 << 20
+	s3PartSizeDoubling = 2000
+	s3MaxParts         = 10000
+	// Objects of local remotes are written to a temporary file next to them and renamed when complete.
+	localPartialSuffix = ".partial"
+	// The checksum manifest written into every backup directory.
+	manifestFileName = "SHA256SUMS"
+
+	defaultRCloneRCAddr  = "127.0.0.1:5572"
+	rcloneRCStartTimeout = 30 * time.Second
+	rcloneRCCallTimeout  = 1 * time.Minute
+	rcloneRCPollInterval = time.Second
 )
@@ -0,0 +1,113 @@
+// Keep MySQL credentials out of config.json and off the xtrabackup command line.
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Load the MySQL password of a target, in this order of precedence: the MYSQL_PASSWORD_<TARGET>
+// environment variable, the password file of the target, the MYSQL_PASSWORD environment variable,
+// a secrets file given by MYSQL_PASSWORD_FILE or mysql_password_file, the password of the target
+// and config.json. Passwords written in config.json come last, so secrets always override them.
+func loadMysqlPassword(target *Target) (string, error) {
+	targetEnv := mysqlPasswordEnv + "_" + strings.ToUpper(strings.ReplaceAll(target.Name, "-", "_"))
+	if password := os.Getenv(targetEnv); password != "" {
+		return password, nil
+	}
+	if target.MysqlPasswordFile != "" {
+		return readPasswordFile(target.MysqlPasswordFile)
+	}
+	if password := os.Getenv(mysqlPasswordEnv); password != "" {
+		return password, nil
+	}
+	path := os.Getenv(mysqlPasswordFileEnv)
+	if path == "" {
+		path = config.MysqlPasswordFile
+	}
+	if path != "" {
+		return readPasswordFile(path)
+	}
+	if target.MysqlPassword != "" {
+		return target.MysqlPassword, nil
+	}
+	return config.MysqlPassword, nil
+}
+
+func readPasswordFile(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("Failed to read MySQL password file: %w", err)
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// Quote a value for a MySQL option file.
+func quoteOptionValue(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	return `"` + value + `"`
+}
+
+// Write the MySQL credentials and connection options of a target to a temporary option file readable only by
+// this user, to be passed to xtrabackup with mysqlDefaultsArg. The returned function removes the file.
+func writeMysqlDefaultsFile(target *Target) (string, func(), error) {
+	file, err := os.CreateTemp("", "mysql-defaults-*.cnf")
+	if err != nil {
+		return "", nil, err
+	}
+	remove := func() { os.Remove(file.Name()) }
+	_, err = file.WriteString(mysqlOptionFile(target))
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		remove()
+		return "", nil, err
+	}
+	return file.Name(), remove, nil
+}
+
+// The content of the option file of a target. A configured defaults file is included first, so that
+// the options written here take precedence.
+func mysqlOptionFile(target *Target) string {
+	var content strings.Builder
+	if target.MysqlDefaultsFile != "" {
+		content.WriteString("!include " + target.MysqlDefaultsFile + "\n\n")
+	}
+	options := [][2]string{
+		{"user", quoteOptionValue(target.MysqlUser)},
+		{"password", quoteOptionValue(target.MysqlPassword)},
+	}
+	if target.MysqlSocket != "" {
+		options = append(options, [2]string{"host", "localhost"}, [2]string{"socket", quoteOptionValue(target.MysqlSocket)})
+	} else {
+		options = append(options, [2]string{"host", quoteOptionValue(target.MysqlHost)}, [2]string{"port", strconv.Itoa(target.MysqlPort)})
+	}
+	if target.MysqlSSLMode != "" {
+		options = append(options, [2]string{"ssl-mode", target.MysqlSSLMode})
+	}
+	if target.MysqlSSLCA != "" {
+		options = append(options, [2]string{"ssl-ca", quoteOptionValue(target.MysqlSSLCA)})
+	}
+	if target.MysqlSSLCert != "" {
+		options = append(options, [2]string{"ssl-cert", quoteOptionValue(target.MysqlSSLCert)}, [2]string{"ssl-key", quoteOptionValue(target.MysqlSSLKey)})
+	}
+	content.WriteString("[client]\n")
+	for _, option := range options {
+		content.WriteString(option[0] + "=" + option[1] + "\n")
+	}
+	return content.String()
+}
+
+// The xtrabackup argument passing the option file of a target. With a configured defaults file, the
+// option file replaces the default option files, as --defaults-extra-file is ignored with --defaults-file.
+func mysqlDefaultsArg(target *Target, path string) string {
+	if target.MysqlDefaultsFile != "" {
+		return "--defaults-file=" + path
+	}
+	return "--defaults-extra-file=" + path
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadMysqlPassword(t *testing.T) {
+	defer func(password string, passwordFile string) {
+		config.MysqlPassword, config.MysqlPasswordFile = password, passwordFile
+	}(config.MysqlPassword, config.MysqlPasswordFile)
+	dir := t.TempDir()
+	targetFile := filepath.Join(dir, "target")
+	secretsFile := filepath.Join(dir, "secrets")
+	for file, password := range map[string]string{targetFile: "target-file\n", secretsFile: "secrets-file\n"} {
+		err := os.WriteFile(file, []byte(password), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name         string
+		targetEnv    string
+		targetFile   string
+		env          string
+		secretsFile  string
+		target       string
+		config       string
+		wantPassword string
+	}{
+		{"target env", "target-env", targetFile, "env", secretsFile, "target", "config", "target-env"},
+		{"target file", "", targetFile, "env", secretsFile, "target", "config", "target-file"},
+		{"env", "", "", "env", secretsFile, "target", "config", "env"},
+		{"secrets file", "", "", "", secretsFile, "target", "config", "secrets-file"},
+		{"target", "", "", "", "", "target", "config", "target"},
+		{"config", "", "", "", "", "", "config", "config"},
+	}
+	for _, test := range tests {
+		t.Setenv("MYSQL_PASSWORD_MAIN_DB", test.targetEnv)
+		t.Setenv(mysqlPasswordEnv, test.env)
+		t.Setenv(mysqlPasswordFileEnv, "")
+		config.MysqlPasswordFile = test.secretsFile
+		config.MysqlPassword = test.config
+		target := &Target{Name: "main-db", MysqlPasswordFile: test.targetFile, MysqlPassword: test.target}
+		got, err := loadMysqlPassword(target)
+		if err != nil || got != test.wantPassword {
+			t.Errorf("%s: loadMysqlPassword = %q, %v, want %q", test.name, got, err, test.wantPassword)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+// Encrypt backups with xtrabackup before they leave the host.
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+)
+
+// Encryption settings. Backups are encrypted with the key KeyID; all keys stay available for
+// decrypting older backups, so a key is rotated by adding a new one and switching KeyID to it.
+type EncryptionConfig struct {
+	// The ID of the key new backups are encrypted with, empty to disable encryption.
+	KeyID string `json:"key_id"`
+	// Paths of the key files by key ID. Each file holds a 32 byte AES-256 key.
+	Keys map[string]string `json:"keys"`
+}
+
+func (c *EncryptionConfig) validate() error {
+	if c.KeyID != "" && c.Keys[c.KeyID] == "" {
+		return fmt.Errorf("key_id %q is not listed in keys", c.KeyID)
+	}
+	for id, path := range c.Keys {
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("key %q: %w", id, err)
+		}
+		if info.Size() != encryptionKeySize {
+			return fmt.Errorf("key %q: %s must hold exactly %d bytes without a trailing newline", id, path, encryptionKeySize)
+		}
+		if info.Mode().Perm()&0o077 != 0 {
+			log.Printf("Warning: encryption key file %s is accessible by other users", path)
+		}
+	}
+	return nil
+}
+
+// xtrabackup arguments encrypting a backup with the given key, none if keyID is empty.
+func encryptionArgs(keyID string) []string {
+	if keyID == "" {
+		return nil
+	}
+	return []string{
+		"--encrypt=AES256",
+		"--encrypt-key-file=" + config.Encryption.Keys[keyID],
+		"--encrypt-threads=" + strconv.Itoa(config.Parallel),
+	}
+}
+
+// xtrabackup arguments decrypting a backup encrypted with the given key.
+func decryptionArgs(keyID string) ([]string, error) {
+	keyFile := config.Encryption.Keys[keyID]
+	if keyFile == "" {
+		return nil, fmt.Errorf("unknown encryption key %q", keyID)
+	}
+	return []string{"--decrypt=AES256", "--encrypt-key-file=" + keyFile}, nil
+}
@@ -2,75 +2,256 @@
 package main
 
 import (
+	"cmp"
+	"context"
+	"database/sql"
+	"fmt"
 	"log"
 	"os"
 	"time"
 )
 
-// A high-level function to perform a full backup and handle tracking and uploading.
-func PerformFullBackup(drive string, comment string) error {
-	backupTime := time.Now()
-	err := CreateFullBackup(backupTime)
+// A high-level function to perform a full backup of a target and handle hooks, tracking and uploading.
+func PerformFullBackup(ctx context.Context, target *Target, drive string, comment string, compression Compression) (err error) {
+	track := newBackupTrack(target, "full", comment, compression)
+	event := newBackupHookEvent(ctx, target, track, cmp.Or(drive, target.DefaultRCloneRemote))
+	defer func() {
+		if err != nil {
+			RunFailureHooks(ctx, event, err)
+		}
+	}()
+	sourceHost, err := CheckReplicationLag(ctx, target)
+	if err != nil {
+		return err
+	}
+	err = RunHooks(ctx, HookPreBackup, event)
+	if err != nil {
+		return err
+	}
+
+	backupCtx, binlog := withBinlogCapture(ctx)
+	if config.StreamBackups {
+		track.RemoteObject, track.Manifest, err = StreamFullBackup(backupCtx, target, track.BackupTime, compression, drive)
+	} else {
+		err = CreateFullBackup(backupCtx, target, track.BackupTime, compression)
+		if err == nil {
+			track.Manifest, err = writeBackupManifest(backupCtx, track)
+		}
+	}
+	track.Binlog = binlog.Info()
+	track.Binlog.SourceHost = sourceHost
 	if err != nil {
 		log.Println(err)
 		return err
 	}
 
-	err = tracker.TrackBackup(backupTime, Saved, "full", comment)
+	err = completeBackup(ctx, target, track, drive, event)
 	if err != nil {
 		return err
 	}
-	go func() {
-		err = UploadToRClone(backupTime, drive, false)
+	ResetSchedule(target.ScheduleName(ScheduleFull))
+	return nil
+}
+
+// A high-level function to perform an incremental backup of a target and handle hooks, tracking and uploading.
+func PerformIncrementalBackup(ctx context.Context, target *Target, drive string, comment string, compression Compression) (err error) {
+	track := newBackupTrack(target, "incremental", comment, compression)
+	event := newBackupHookEvent(ctx, target, track, cmp.Or(drive, target.DefaultRCloneRemote))
+	defer func() {
 		if err != nil {
-			log.Println(err)
-			return
+			RunFailureHooks(ctx, event, err)
 		}
-		tracker.UpdateBackupStatus(backupTime, Uploaded)
 	}()
+	lastBackupTime, isIncremental, err := tracker.GetLastBackupTime(target.Name)
+	if err != nil {
+		return err
+	}
+	sourceHost, err := CheckReplicationLag(ctx, target)
+	if err != nil {
+		return err
+	}
+	err = RunHooks(ctx, HookPreBackup, event)
+	if err != nil {
+		return err
+	}
+
+	backupCtx, binlog := withBinlogCapture(ctx)
+	if config.StreamBackups {
+		track.RemoteObject, track.Manifest, err = StreamIncrementalBackup(backupCtx, target, track.BackupTime, lastBackupTime, isIncremental, compression, drive)
+	} else {
+		err = CreateIncrementalBackup(backupCtx, target, track.BackupTime, lastBackupTime, isIncremental, compression)
+		if err == nil {
+			track.Manifest, err = writeBackupManifest(backupCtx, track)
+		}
+	}
+	track.Binlog = binlog.Info()
+	track.Binlog.SourceHost = sourceHost
+	if err != nil {
+		log.Println(err)
+		return err
+	}
 
-	fullBackupTicker.Reset(config.FullBackupInterval)
+	err = completeBackup(ctx, target, track, drive, event)
+	if err != nil {
+		return err
+	}
+	ResetSchedule(target.ScheduleName(ScheduleIncremental))
 	return nil
 }
 
-// A high-level function to perform an incremental backup and handle tracking and uploading.
-func PerformIncrementalBackup(drive string, comment string) error {
-	backupTime := time.Now()
-	lastBackupTime, isIncremental, err := tracker.GetLastBackupTime()
+// Write the checksum manifest of a created backup. The backup is removed if it fails.
+func writeBackupManifest(ctx context.Context, track DatabaseTrack) (Manifest, error) {
+	manifest, err := WriteManifest(ctx, track.GetBackupPath())
 	if err != nil {
-		return err
+		RemovePartialOutput(track.GetBackupPath())
+		return nil, fmt.Errorf("Failed to write manifest of backup %s: %w", track.GetBackupPath(), err)
+	}
+	return manifest, nil
+}
+
+// A track for a backup starting now, with the settings it is created with.
+func newBackupTrack(target *Target, backupType string, comment string, compression Compression) DatabaseTrack {
+	return DatabaseTrack{
+		Target:          target.Name,
+		BackupTime:      time.Now(),
+		Status:          Saved,
+		Type:            backupType,
+		Comment:         comment,
+		EncryptionKeyID: config.Encryption.KeyID,
+		Compression:     compression.Algorithm,
 	}
+}
 
-	err = CreateIncrementalBackup(backupTime, lastBackupTime, isIncremental)
+// Run the post-backup hooks of a created backup, then track it and queue its upload. If a hook aborts,
+// the backup is discarded.
+func completeBackup(ctx context.Context, target *Target, track DatabaseTrack, drive string, event HookEvent) error {
+	event.RemoteObject = track.RemoteObject
+	err := RunHooks(ctx, HookPostBackup, event)
 	if err != nil {
-		log.Println(err)
+		RemovePartialOutput(track.GetBackupPath())
+		if track.IsStreamed() {
+			DeleteRemoteObject(ctx, track.RemoteObject)
+		}
 		return err
 	}
+	return trackAndUploadBackup(target, track, drive)
+}
 
-	err = tracker.TrackBackup(backupTime, Saved, "incremental", comment)
-	if err != nil {
+// Track a created backup and queue its upload, to the default remote of the target unless drive
+// is given. Streamed backups are already uploaded and verified.
+func trackAndUploadBackup(target *Target, track DatabaseTrack, drive string) error {
+	if track.IsStreamed() {
+		track.Status = Uploaded
+	}
+	backupID, err := tracker.TrackBackup(track)
+	if err != nil || track.IsStreamed() {
 		return err
 	}
-	go func() {
-		err = UploadToRClone(backupTime, drive, true)
+	err = EnqueueUpload(backupID, cmp.Or(drive, target.DefaultRCloneRemote))
+	if err != nil {
+		log.Printf("Failed to queue upload of backup %s: %v\n", track.BackupTime.Format(time.DateTime), err)
+	}
+	return nil
+}
+
+// Download a backup, then decrypt it with the key it was encrypted with and decompress it. The key
+// is looked up in the tracker unless keyID is given, e.g. for backups of another installation.
+// Backups unknown to the tracker are decompressed in case they are compressed.
+func PerformDownload(ctx context.Context, target *Target, remote string, backupName string, keyID string) (err error) {
+	event := HookEvent{
+		Target:     target.Name,
+		BackupName: backupName,
+		BackupPath: target.DownloadDir + DownloadDirName(backupName),
+		Remote:     cmp.Or(remote, target.DefaultRCloneRemote),
+	}
+	if job := JobFromContext(ctx); job != nil {
+		event.JobID = job.ID
+	}
+	defer func() {
 		if err != nil {
-			log.Println(err)
-			return
+			RunFailureHooks(ctx, event, err)
 		}
-		tracker.UpdateBackupStatus(backupTime, Uploaded)
 	}()
+	err = RunHooks(ctx, HookPreRestore, event)
+	if err != nil {
+		return err
+	}
+
+	err = DownloadFromRemote(ctx, target, remote, backupName)
+	if err != nil {
+		return err
+	}
+	track, err := tracker.GetBackupByName(target.Name, DownloadDirName(backupName))
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if keyID == "" {
+		keyID = track.EncryptionKeyID
+	}
+	decompress := track.Compression != "none"
+	return UnpackBackup(ctx, target.DownloadDir+DownloadDirName(backupName), keyID, decompress)
+}
 
-	incrementalBackupTicker.Reset(config.IncrementalBackupInterval)
+// Delete local backups of all targets that exceed their local backup count. A full backup and its
+// incremental backups are only deleted once all of them are uploaded, so that backups whose upload is
+// pending or dead keep their only copy.
+func CleanupOldBackups() error {
+	var backups []DatabaseTrack
+	for _, target := range config.Targets {
+		targetBackups, err := tracker.GetOldBackups(target)
+		if err != nil {
+			return err
+		}
+		backups = append(backups, targetBackups...)
+	}
+	for _, backup := range backups {
+		uploaded, err := isChainUploaded(backup)
+		if err != nil {
+			log.Printf("Failed to check uploads of backup %s: %v", backup.GetBackupPath(), err)
+			continue
+		}
+		if !uploaded {
+			log.Printf("Keeping local backup %s until it and its incremental backups are uploaded\n", backup.GetBackupPath())
+			continue
+		}
+		err = DeleteLocalBackup(backup)
+		if err != nil {
+			log.Printf("Failed to delete local backup %s: %v", backup.GetBackupPath(), err)
+		}
+	}
 	return nil
 }
 
+// Whether a backup, and for a full backup every incremental backup based on it, is uploaded and has
+// no pending, running or dead upload left.
+func isChainUploaded(track DatabaseTrack) (bool, error) {
+	tracks := []DatabaseTrack{track}
+	if track.IsFullBackup() {
+		incrementalTracks, err := tracker.GetIncrementalTracks(track)
+		if err != nil {
+			return false, err
+		}
+		tracks = append(tracks, incrementalTracks...)
+	}
+	for _, t := range tracks {
+		if t.Status == Saved {
+			return false, nil
+		}
+		undelivered, err := tracker.HasUndeliveredUpload(t.ID)
+		if err != nil || undelivered {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
 // Delete old backup.
 func DeleteLocalBackup(track DatabaseTrack) error {
 	err := os.RemoveAll(track.GetBackupPath())
 	if err != nil {
 		return err
 	}
-	tracker.UpdateBackupStatus(track.BackupTime, Archived)
+	tracker.UpdateBackupStatus(track.ID, Archived)
 	log.Printf("Deleted local backup %s\n", track.GetBackupPath())
 	// If this is a full backup, also delete incremental backups based on it.
 	if track.IsFullBackup() {
@@ -83,7 +264,7 @@ func DeleteLocalBackup(track DatabaseTrack) error {
 			if err != nil {
 				return err
 			}
-			tracker.UpdateBackupStatus(incTrack.BackupTime, Archived)
+			tracker.UpdateBackupStatus(incTrack.ID, Archived)
 			log.Printf("Deleted local incremental backup %s\n", incTrack.GetBackupPath())
 		}
 	}
@@ -0,0 +1,220 @@
+// Run configured shell commands and webhooks around backups, uploads and restores.
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// The points at which hooks run.
+const (
+	// Before a backup is created. A failing hook with abort_on_failure cancels the backup.
+	HookPreBackup = "pre-backup"
+	// After a backup is created, before it is tracked and uploaded. A failing hook with abort_on_failure
+	// discards the backup.
+	HookPostBackup = "post-backup"
+	// After a backup is uploaded.
+	HookPostUpload = "post-upload"
+	// Before a backup is downloaded. A failing hook with abort_on_failure cancels the download.
+	HookPreRestore = "pre-restore"
+	// After a backup or download failed, or an upload was given up.
+	HookOnFailure = "on-failure"
+)
+
+var hookEvents = []string{HookPreBackup, HookPostBackup, HookPostUpload, HookPreRestore, HookOnFailure}
+
+// Events at which a failing hook may abort the operation.
+var abortableHookEvents = []string{HookPreBackup, HookPostBackup, HookPreRestore}
+
+// A shell command or webhook run at an event. Commands run with sh -c and receive the event as BACKUP_*
+// environment variables and as JSON on stdin; webhooks receive the JSON in a POST request.
+type Hook struct {
+	Name    string `json:"name"`
+	Event   string `json:"event"`
+	Command string `json:"command"`
+	URL     string `json:"url"`
+	// Only run for these targets, all targets if empty.
+	Targets        []string `json:"targets"`
+	TimeoutStr     string   `json:"timeout"`
+	AbortOnFailure bool     `json:"abort_on_failure"`
+
+	Timeout time.Duration `json:"-"`
+}
+
+// The metadata passed to hooks.
+type HookEvent struct {
+	Event        string    `json:"event"`
+	Target       string    `json:"target"`
+	JobID        int64     `json:"job_id,omitempty"`
+	BackupType   string    `json:"backup_type,omitempty"`
+	BackupTime   time.Time `json:"backup_time,omitzero"`
+	BackupName   string    `json:"backup_name,omitempty"`
+	BackupPath   string    `json:"backup_path,omitempty"`
+	Remote       string    `json:"remote,omitempty"`
+	RemoteObject string    `json:"remote_object,omitempty"`
+	Comment      string    `json:"comment,omitempty"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// The environment variables passed to hook commands.
+func (e HookEvent) environ() []string {
+	env := []string{
+		"BACKUP_HOOK_EVENT=" + e.Event,
+		"BACKUP_TARGET=" + e.Target,
+		"BACKUP_JOB_ID=" + strconv.FormatInt(e.JobID, 10),
+		"BACKUP_TYPE=" + e.BackupType,
+		"BACKUP_NAME=" + e.BackupName,
+		"BACKUP_PATH=" + e.BackupPath,
+		"BACKUP_REMOTE=" + e.Remote,
+		"BACKUP_REMOTE_OBJECT=" + e.RemoteObject,
+		"BACKUP_COMMENT=" + e.Comment,
+		"BACKUP_ERROR=" + e.Error,
+	}
+	if !e.BackupTime.IsZero() {
+		env = append(env, "BACKUP_TIME="+e.BackupTime.Format(time.RFC3339))
+	}
+	return env
+}
+
+// The hook event of a backup of a target, before it is created.
+func newBackupHookEvent(ctx context.Context, target *Target, track DatabaseTrack, remote string) HookEvent {
+	event := HookEvent{
+		Target:     target.Name,
+		BackupType: track.Type,
+		BackupTime: track.BackupTime,
+		BackupName: filepath.Base(track.GetBackupPath()),
+		BackupPath: track.GetBackupPath(),
+		Remote:     remote,
+		Comment:    track.Comment,
+	}
+	if job := JobFromContext(ctx); job != nil {
+		event.JobID = job.ID
+	}
+	return event
+}
+
+// Check the configured hooks and fill in their timeouts.
+func validateHooks() error {
+	for i, hook := range config.Hooks {
+		hook.Name = cmp.Or(hook.Name, "#"+strconv.Itoa(i+1))
+		name := hook.Name
+		if !slices.Contains(hookEvents, hook.Event) {
+			return fmt.Errorf("hook %s: invalid event %q", name, hook.Event)
+		}
+		if (hook.Command == "") == (hook.URL == "") {
+			return fmt.Errorf("hook %s: set either command or url", name)
+		}
+		if hook.AbortOnFailure && !slices.Contains(abortableHookEvents, hook.Event) {
+			return fmt.Errorf("hook %s: abort_on_failure is not supported for %s hooks", name, hook.Event)
+		}
+		for _, target := range hook.Targets {
+			if GetTarget(target) == nil {
+				return fmt.Errorf("hook %s: unknown target %q", name, target)
+			}
+		}
+		hook.Timeout = defaultHookTimeout
+		if hook.TimeoutStr != "" {
+			timeout, err := time.ParseDuration(hook.TimeoutStr)
+			if err != nil || timeout <= 0 {
+				return fmt.Errorf("hook %s: invalid timeout %q", name, hook.TimeoutStr)
+			}
+			hook.Timeout = timeout
+		}
+	}
+	return nil
+}
+
+// Run the hooks of an event one after another. Failures are logged; the first failure of a hook
+// with abort_on_failure stops the remaining hooks and is returned.
+func RunHooks(ctx context.Context, eventName string, event HookEvent) error {
+	event.Event = eventName
+	for _, hook := range config.Hooks {
+		if hook.Event != eventName || (len(hook.Targets) > 0 && !slices.Contains(hook.Targets, event.Target)) {
+			continue
+		}
+		name := hook.Name
+		JobLogf(ctx, "Running %s hook %s", eventName, name)
+		err := hook.run(ctx, event)
+		if err == nil {
+			continue
+		}
+		if errors.Is(err, context.Canceled) {
+			return err
+		}
+		log.Printf("The %s hook %s failed: %v", eventName, name, err)
+		JobLogf(ctx, "The %s hook %s failed: %v", eventName, name, err)
+		if hook.AbortOnFailure {
+			return fmt.Errorf("%s hook %s failed: %w", eventName, name, err)
+		}
+	}
+	return nil
+}
+
+// Run the on-failure hooks for an operation that failed with err. Cancelled operations are not reported,
+// and the hooks run even if ctx has timed out.
+func RunFailureHooks(ctx context.Context, event HookEvent, err error) {
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	event.Error = err.Error()
+	RunHooks(context.WithoutCancel(ctx), HookOnFailure, event)
+}
+
+func (h *Hook) run(ctx context.Context, event HookEvent) error {
+	ctx, cancel := context.WithTimeout(ctx, h.Timeout)
+	defer cancel()
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if h.URL != "" {
+		return postHook(ctx, h.URL, body)
+	}
+
+	tail := newTailBuffer(subprocessOutputTailLines)
+	cmd := newSubprocess(ctx, tail, "sh", "-c", h.Command)
+	// Terminate the whole process group, so that commands started by the shell do not outlive the timeout.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+	}
+	cmd.Env = append(os.Environ(), event.environ()...)
+	cmd.Stdin = bytes.NewReader(body)
+	err = cmd.Run()
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		return fmt.Errorf("%w, output: %s", err, tail.String())
+	}
+	return nil
+}
+
+func postHook(ctx context.Context, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
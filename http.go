@@ -4,23 +4,133 @@
 package main
 
 import (
+	"bytes"
+	"cmp"
+	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 )
 
-// POST /full
-// Trigger a full backup.
-// Response: 204 No Content on success, 400 Bad Request on invalid input, 500 Internal Server Error on failure.
+// Reject a manual request that falls inside a blackout window with 503 Service Unavailable.
+func rejectInBlackout(w http.ResponseWriter) bool {
+	window, end, active := InBlackout(time.Now())
+	if !active {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(end).Seconds())+1))
+	http.Error(w, fmt.Sprintf("Blackout window %q is active until %s, set ignore_blackout to override", window.Reason, end.Format(time.RFC3339)), http.StatusServiceUnavailable)
+	return true
+}
+
+// Respond to a manual job submission with 202 Accepted and the queued job, or with
+// the outcome of the job if the caller asked to wait for it.
+func respondToSubmittedJob(w http.ResponseWriter, r *http.Request, job *Job, err error, label string) {
+	if err == ErrJobConflict {
+		w.Header().Set("Location", fmt.Sprintf("/jobs/%d", job.ID))
+		http.Error(w, fmt.Sprintf("%s job #%d is already queued or running", label, job.ID), http.StatusConflict)
+		return
+	}
+	if err == ErrShuttingDown {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to queue %s job: %v", strings.ToLower(label), err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/jobs/%d", job.ID))
+	if r.URL.Query().Get("wait") != "true" {
+		writeJSON(w, http.StatusAccepted, job.Info())
+		return
+	}
+	err = job.Wait()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("%s failed: %v", label, err), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Resolve the target named in the request path, or the default target for the legacy endpoints.
+// Writes 404 Not Found and returns nil for an unknown target.
+func requestTarget(w http.ResponseWriter, r *http.Request) *Target {
+	name := r.PathValue("target")
+	if name == "" {
+		return DefaultTarget()
+	}
+	target := GetTarget(name)
+	if target == nil {
+		http.Error(w, fmt.Sprintf("Unknown target %q", name), http.StatusNotFound)
+	}
+	return target
+}
+
+// GET /targets
+// List the configured MySQL targets, without credentials.
+// Response: 200 OK with a JSON array of targets.
+func HandleListTargets(w http.ResponseWriter, r *http.Request) {
+	type TargetInfo struct {
+		Name                string `json:"name"`
+		MysqlHost           string `json:"mysql_host"`
+		MysqlPort           int    `json:"mysql_port"`
+		MysqlSocket         string `json:"mysql_socket,omitempty"`
+		Datadir             string `json:"datadir"`
+		BackupDir           string `json:"backup_dir"`
+		DownloadDir         string `json:"download_dir"`
+		DefaultRCloneRemote string `json:"default_rclone_remote"`
+	}
+	targets := make([]TargetInfo, 0, len(config.Targets))
+	for _, t := range config.Targets {
+		targets = append(targets, TargetInfo{
+			Name:                t.Name,
+			MysqlHost:           t.MysqlHost,
+			MysqlPort:           t.MysqlPort,
+			MysqlSocket:         t.MysqlSocket,
+			Datadir:             t.Datadir,
+			BackupDir:           t.BackupDir,
+			DownloadDir:         t.DownloadDir,
+			DefaultRCloneRemote: t.DefaultRCloneRemote,
+		})
+	}
+	writeJSON(w, http.StatusOK, targets)
+}
+
+// POST /full, POST /targets/{target}/full
+// Queue a full backup of the target, or of the first configured target for /full.
+// Response: 202 Accepted with the queued job and its URL in the Location header, 400 Bad Request on invalid input,
+// 409 Conflict if a backup of the same type is already queued or running,
+// 503 Service Unavailable inside a blackout window, 500 Internal Server Error on failure.
+// With wait=true, the request blocks until the backup has finished and returns 204 No Content on success.
+// Query parameters:
+//
+//	wait (bool, optional): Wait for the backup to finish.
+//
 // Request body:
 //
-//	drive (string): The rclone drive name to upload the backup to.
+//	drive (string, optional): The remote to upload the backup to, e.g. "onedrive:", the default remote of the target if empty.
 //	comment (string, optional): An optional comment for the backup.
+//	ignore_blackout (bool, optional): Run the backup even inside a blackout window.
+//	compression (object, optional): Override the configured compression with algorithm (zstd, lz4 or none), level and threads.
 func HandleFullBackup(w http.ResponseWriter, r *http.Request) {
 	type FullBackupRequest struct {
-		Drive   string `json:"drive,omitempty"`
-		Comment string `json:"comment,omitempty"`
+		Drive          string       `json:"drive,omitempty"`
+		Comment        string       `json:"comment,omitempty"`
+		IgnoreBlackout bool         `json:"ignore_blackout,omitempty"`
+		Compression    *Compression `json:"compression,omitempty"`
+	}
+	target := requestTarget(w, r)
+	if target == nil {
+		return
 	}
 	var req FullBackupRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
@@ -31,26 +141,47 @@ func HandleFullBackup(w http.ResponseWriter, r *http.Request) {
 	if req.Comment == "" {
 		req.Comment = "Manual full backup"
 	}
-	log.Printf("Received full backup request: drive=%s, comment=%s", req.Drive, req.Comment)
-	err = PerformFullBackup(req.Drive, req.Comment)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Full backup failed: %v", err), http.StatusInternalServerError)
+	if req.Compression != nil {
+		err = req.Compression.normalize()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid compression: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	log.Printf("Received full backup request: target=%s, drive=%s, comment=%s", target.Name, req.Drive, req.Comment)
+	if !req.IgnoreBlackout && rejectInBlackout(w) {
 		return
 	}
-	w.WriteHeader(http.StatusNoContent)
+	job, err := SubmitFullBackup(target, req.Drive, req.Comment, req.Compression)
+	respondToSubmittedJob(w, r, job, err, "Full backup")
 }
 
-// POST /incremental
-// Trigger an incremental backup.
-// Response: 204 No Content on success, 400 Bad Request on invalid input, 500 Internal Server Error on failure.
+// POST /incremental, POST /targets/{target}/incremental
+// Queue an incremental backup of the target, or of the first configured target for /incremental.
+// Response: 202 Accepted with the queued job and its URL in the Location header, 400 Bad Request on invalid input,
+// 409 Conflict if a backup of the same type is already queued or running,
+// 503 Service Unavailable inside a blackout window, 500 Internal Server Error on failure.
+// With wait=true, the request blocks until the backup has finished and returns 204 No Content on success.
+// Query parameters:
+//
+//	wait (bool, optional): Wait for the backup to finish.
+//
 // Request body:
 //
-//	drive (string): The rclone drive name to upload the backup to.
+//	drive (string, optional): The remote to upload the backup to, e.g. "onedrive:", the default remote of the target if empty.
 //	comment (string, optional): An optional comment for the backup.
+//	ignore_blackout (bool, optional): Run the backup even inside a blackout window.
+//	compression (object, optional): Override the configured compression with algorithm (zstd, lz4 or none), level and threads.
 func HandleIncrementalBackup(w http.ResponseWriter, r *http.Request) {
 	type IncrementalBackupRequest struct {
-		Drive   string `json:"drive,omitempty"`
-		Comment string `json:"comment,omitempty"`
+		Drive          string       `json:"drive,omitempty"`
+		Comment        string       `json:"comment,omitempty"`
+		IgnoreBlackout bool         `json:"ignore_blackout,omitempty"`
+		Compression    *Compression `json:"compression,omitempty"`
+	}
+	target := requestTarget(w, r)
+	if target == nil {
+		return
 	}
 	var req IncrementalBackupRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
@@ -61,41 +192,810 @@ func HandleIncrementalBackup(w http.ResponseWriter, r *http.Request) {
 	if req.Comment == "" {
 		req.Comment = "Manual incremental backup"
 	}
-	log.Printf("Received incremental backup request: drive=%s, comment=%s", req.Drive, req.Comment)
-	err = PerformIncrementalBackup(req.Drive, req.Comment)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Incremental backup failed: %v", err), http.StatusInternalServerError)
+	if req.Compression != nil {
+		err = req.Compression.normalize()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid compression: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	log.Printf("Received incremental backup request: target=%s, drive=%s, comment=%s", target.Name, req.Drive, req.Comment)
+	if !req.IgnoreBlackout && rejectInBlackout(w) {
 		return
 	}
-	w.WriteHeader(http.StatusNoContent)
+	job, err := SubmitIncrementalBackup(target, req.Drive, req.Comment, req.Compression)
+	respondToSubmittedJob(w, r, job, err, "Incremental backup")
 }
 
-// POST /download
-// Download a backup from rclone.
-// Response: 204 No Content on success, 400 Bad Request on invalid input, 500 Internal Server Error on failure.
+// POST /download, POST /targets/{target}/download
+// Queue a download of a backup of the target from a remote, or of the first configured target for /download.
+// Response: 202 Accepted with the queued job and its URL in the Location header, 400 Bad Request on invalid input,
+// 409 Conflict if the same backup is already being downloaded, 500 Internal Server Error on failure.
+// With wait=true, the request blocks until the download has finished and returns 204 No Content on success.
+// Query parameters:
+//
+//	wait (bool, optional): Wait for the download to finish.
+//
 // Request body:
 //
-//	drive (string): The rclone drive name to download the backup from.
+//	drive (string, optional): The remote to download the backup from, the default remote of the target if empty.
 //	backup_name (string): The backup filename.
+//	encryption_key_id (string, optional): The key to decrypt the backup with, if it is not tracked by this service.
+//	  Required for backups tracked with an unknown key, e.g. by rebuilding the catalog.
 func HandleDownloadBackup(w http.ResponseWriter, r *http.Request) {
 	type DownloadBackupRequest struct {
-		Drive      string `json:"drive,omitempty"`
-		BackupName string `json:"backup_name"`
+		Drive           string `json:"drive,omitempty"`
+		BackupName      string `json:"backup_name"`
+		EncryptionKeyID string `json:"encryption_key_id,omitempty"`
+	}
+	target := requestTarget(w, r)
+	if target == nil {
+		return
 	}
 	var req DownloadBackupRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.BackupName == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if _, _, ok := ParseBackupName(req.BackupName); !ok {
+		http.Error(w, fmt.Sprintf("Invalid backup name %q, expected e.g. db_20251130_1200 or db_20251130_1200_inc.xbstream", req.BackupName), http.StatusBadRequest)
+		return
+	}
+	if req.EncryptionKeyID != "" && config.Encryption.Keys[req.EncryptionKeyID] == "" {
+		http.Error(w, fmt.Sprintf("Unknown encryption key %q", req.EncryptionKeyID), http.StatusBadRequest)
+		return
+	}
+	if req.EncryptionKeyID == "" {
+		track, err := tracker.GetBackupByName(target.Name, DownloadDirName(req.BackupName))
+		if err != nil && err != sql.ErrNoRows {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if track.EncryptionKeyUnknown {
+			http.Error(w, fmt.Sprintf("Backup %s is encrypted with an unknown key, set encryption_key_id", req.BackupName), http.StatusBadRequest)
+			return
+		}
+	}
+	log.Printf("Received download backup request: target=%s, drive=%s, backup_name=%s", target.Name, req.Drive, req.BackupName)
+	job, err := SubmitDownload(target, req.Drive, req.BackupName, req.EncryptionKeyID)
+	respondToSubmittedJob(w, r, job, err, "Download")
+}
+
+// DELETE /jobs/{id}
+// Cancel a queued or running job. A running subprocess is terminated and its partial output removed.
+// Response: 202 Accepted with the job on success, 400 Bad Request on invalid ID,
+// 404 Not Found if no queued or running job has this ID.
+func HandleCancelJob(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid job ID", http.StatusBadRequest)
+		return
+	}
+	log.Printf("Received cancel job request: id=%d", id)
+	job, err := jobQueue.Cancel(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, job.Info())
+}
+
+// Resolve the scheduled jobs targeted by a scheduler request. An empty name means all jobs, a job
+// name without target such as "full" means that job of every target.
+func scheduleNames(job string) ([]string, error) {
+	schedulesMu.Lock()
+	defer schedulesMu.Unlock()
+	var names []string
+	for _, s := range schedules {
+		if job == "" || s.Name == job || s.Job == job {
+			names = append(names, s.Name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("unknown scheduled job %q", job)
+	}
+	return names, nil
+}
+
+// GET /jobs
+// List jobs, newest first. Active jobs include their live progress.
+// Response: 200 OK with a JSON array of jobs, 400 Bad Request on invalid input.
+// Query parameters:
+//
+//	kind (string, optional): Only list jobs of this kind: full, incremental, upload, download, cleanup or scrub.
+//	target (string, optional): Only list jobs of this target.
+//	state (string, optional): Only list jobs in this state: queued, running, succeeded, failed, cancelled or interrupted.
+//	limit (int, optional): Maximum number of jobs, 50 by default.
+func HandleListJobs(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var filter JobFilter
+	var err error
+	if value := query.Get("kind"); value != "" {
+		filter.Kind, err = ParseJobKind(value)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter.HasKind = true
+	}
+	if value := query.Get("state"); value != "" {
+		filter.State, err = ParseJobState(value)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter.HasState = true
+	}
+	filter.Target = query.Get("target")
+	filter.Limit = defaultJobListLimit
+	if value := query.Get("limit"); value != "" {
+		filter.Limit, err = strconv.Atoi(value)
+		if err != nil || filter.Limit <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	jobs, err := tracker.ListJobs(filter)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list jobs: %v", err), http.StatusInternalServerError)
+		return
+	}
+	for i := range jobs {
+		if job := jobQueue.Get(jobs[i].ID); job != nil {
+			jobs[i] = job.Info()
+		}
+	}
+	if jobs == nil {
+		jobs = []JobInfo{}
+	}
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+// Get a job from the queue if it is active, otherwise from the tracker.
+func lookupJob(id int64) (JobInfo, error) {
+	if job := jobQueue.Get(id); job != nil {
+		return job.Info(), nil
+	}
+	return tracker.GetJob(id)
+}
+
+// GET /jobs/{id}
+// Get the state of a job, including the live progress of a running job.
+// Response: 200 OK with the job, 400 Bad Request on invalid ID, 404 Not Found if the job does not exist.
+func HandleGetJob(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid job ID", http.StatusBadRequest)
+		return
+	}
+	job, err := lookupJob(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get job: %v", err), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// GET /jobs/{id}/events
+// Stream the state and progress of a job as Server-Sent Events. A "progress" event carrying the job
+// is sent whenever it changes, followed by a "done" event once the job has finished.
+// Response: 200 OK with a text/event-stream, 400 Bad Request on invalid ID, 404 Not Found if the job does not exist.
+func HandleJobEvents(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid job ID", http.StatusBadRequest)
+		return
+	}
+	job, err := lookupJob(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get job: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	ticker := time.NewTicker(progressReportInterval)
+	defer ticker.Stop()
+	var last []byte
+	for {
+		data, err := json.Marshal(job)
+		if err != nil {
+			log.Printf("Failed to encode job #%d: %v", id, err)
+			return
+		}
+		event := "progress"
+		if job.IsFinished() {
+			event = "done"
+		}
+		if event == "done" || !bytes.Equal(data, last) {
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+			if flusher != nil {
+				flusher.Flush()
+			}
+			last = data
+		}
+		if event == "done" {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+		job, err = lookupJob(id)
+		if err != nil {
+			log.Printf("Failed to get job #%d: %v", id, err)
+			return
+		}
+	}
+}
+
+// GET /jobs/{id}/log
+// Get the output of a job as plain text.
+// Response: 200 OK with the log, 400 Bad Request on invalid input, 404 Not Found if the job or its log does not exist.
+// Query parameters:
+//
+//	tail (int, optional): Only return the last lines of the log.
+//	follow (bool, optional): Keep the response open and stream new output until the job finishes.
+func HandleJobLog(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid job ID", http.StatusBadRequest)
+		return
+	}
+	tail := 0
+	if value := r.URL.Query().Get("tail"); value != "" {
+		tail, err = strconv.Atoi(value)
+		if err != nil || tail < 0 {
+			http.Error(w, "Invalid tail", http.StatusBadRequest)
+			return
+		}
+	}
+	follow := r.URL.Query().Get("follow") == "true"
+
+	path, err := tracker.GetJobLogPath(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get job: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if path == "" {
+		http.Error(w, "Job has no log yet", http.StatusNotFound)
+		return
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		http.Error(w, "Job log has expired", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to open job log: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to open job log: %v", err), http.StatusInternalServerError)
+		return
+	}
+	// Read exactly the current size, so that following continues right after it.
+	data, err := ReadLastLines(io.LimitReader(file, info.Size()), tail)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read job log: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(data)
+	if !follow {
+		return
+	}
+	flusher, _ := w.(http.Flusher)
+	ticker := time.NewTicker(jobLogFollowInterval)
+	defer ticker.Stop()
+	for {
+		active := jobQueue.Get(id) != nil
+		io.Copy(w, file)
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if !active {
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// GET /uploads
+// List uploads, newest first, with their attempts and last error.
+// Response: 200 OK with a JSON array of uploads, 400 Bad Request on invalid input.
+// Query parameters:
+//
+//	state (string, optional): Only list uploads in this state: pending, running, done or dead.
+//	limit (int, optional): Maximum number of uploads, 50 by default.
+func HandleListUploads(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var state *UploadState
+	if value := query.Get("state"); value != "" {
+		parsed, err := ParseUploadState(value)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		state = &parsed
+	}
+	limit := defaultJobListLimit
+	if value := query.Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	uploads, err := tracker.ListUploads(state, limit)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list uploads: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if uploads == nil {
+		uploads = []Upload{}
+	}
+	writeJSON(w, http.StatusOK, uploads)
+}
+
+// POST /uploads/{id}/retry
+// Retry a dead or pending upload immediately, resetting its attempt count.
+// Response: 202 Accepted with the upload, 400 Bad Request on invalid ID, 404 Not Found if the upload does not exist,
+// 409 Conflict if the upload is running or already done.
+func HandleRetryUpload(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid upload ID", http.StatusBadRequest)
+		return
+	}
+	log.Printf("Received retry upload request: id=%d", id)
+	upload, err := tracker.GetUpload(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Upload not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get upload: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if upload.State != UploadDead && upload.State != UploadPending {
+		http.Error(w, fmt.Sprintf("Upload #%d is %s", id, upload.State), http.StatusConflict)
+		return
+	}
+
+	err = tracker.RetryUpload(id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to retry upload: %v", err), http.StatusInternalServerError)
+		return
+	}
+	WakeUploadDispatcher()
+	upload, err = tracker.GetUpload(id)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get upload: %v", err), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, upload)
+}
+
+// GET /remotes/{remote}/backups
+// List the backups of a target found on a remote, oldest first, to pick one to download.
+// Response: 200 OK with a JSON array of backups, 400 Bad Request on an invalid remote,
+// 404 Not Found for an unknown target, 502 Bad Gateway if the remote cannot be listed.
+// Path parameters:
+//
+//	remote (string): The remote, e.g. onedrive or onedrive:.
+//
+// Query parameters:
+//
+//	target (string, optional): The target whose backups are listed, the default target if omitted.
+func HandleListRemoteBackups(w http.ResponseWriter, r *http.Request) {
+	remote, target := requestRemoteTarget(w, r)
+	if target == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), remoteListTimeout)
+	defer cancel()
+	backups, err := ListRemoteBackups(ctx, target, remote)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	writeJSON(w, http.StatusOK, backups)
+}
+
+// POST /remotes/{remote}/rebuild
+// Track the backups of a target found on a remote that are unknown to the tracker, e.g. after the
+// tracking database was lost.
+// Response: 200 OK with a JSON array of the backups on the remote, oldest first, 400 Bad Request on an
+// invalid remote, 404 Not Found for an unknown target, 502 Bad Gateway if the remote cannot be read.
+// Path parameters:
+//
+//	remote (string): The remote, e.g. onedrive or onedrive:.
+//
+// Query parameters:
+//
+//	target (string, optional): The target whose backups are tracked, the default target if omitted.
+func HandleRebuildCatalog(w http.ResponseWriter, r *http.Request) {
+	remote, target := requestRemoteTarget(w, r)
+	if target == nil {
+		return
+	}
+	log.Printf("Received catalog rebuild request for %s on %s\n", target.Name, remote)
+	ctx, cancel := context.WithTimeout(r.Context(), catalogRebuildTimeout)
+	defer cancel()
+	backups, err := RebuildCatalog(ctx, target, remote)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	writeJSON(w, http.StatusOK, backups)
+}
+
+// Resolve the remote in the request path and the target of the target query parameter, the default
+// target if it is omitted. Writes 400 Bad Request on an invalid remote or 404 Not Found for an unknown
+// target and returns a nil target.
+func requestRemoteTarget(w http.ResponseWriter, r *http.Request) (string, *Target) {
+	remote := strings.TrimSuffix(r.PathValue("remote"), ":")
+	if !remoteNameRegexp.MatchString(remote) {
+		http.Error(w, fmt.Sprintf("Invalid remote %q", remote), http.StatusBadRequest)
+		return "", nil
+	}
+	target := DefaultTarget()
+	if name := r.URL.Query().Get("target"); name != "" {
+		target = GetTarget(name)
+		if target == nil {
+			http.Error(w, fmt.Sprintf("Unknown target %q", name), http.StatusNotFound)
+		}
+	}
+	return remote + ":", target
+}
+
+// POST /scrub
+// Verify local backups and a sample of remote backup copies against their manifests now.
+// Response: 202 Accepted with the queued job, 409 Conflict if a scrub is already queued or running.
+// With ?wait=true: 204 No Content once the scrub passed, 500 Internal Server Error if it found corrupted
+// or unverifiable copies.
+func HandleScrub(w http.ResponseWriter, r *http.Request) {
+	log.Println("Received scrub request")
+	job, err := SubmitScrub()
+	respondToSubmittedJob(w, r, job, err, "Scrub")
+}
+
+// GET /scrubs
+// List the last scrub outcome of every scrubbed backup copy, least recently verified first.
+// Response: 200 OK with a JSON array of scrub results, 400 Bad Request on invalid input.
+// Query parameters:
+//
+//	outcome (string, optional): Only list copies with this outcome: ok, corrupted or failed.
+func HandleListScrubs(w http.ResponseWriter, r *http.Request) {
+	outcome := r.URL.Query().Get("outcome")
+	if outcome != "" && outcome != ScrubOK && outcome != ScrubCorrupted && outcome != ScrubFailed {
+		http.Error(w, fmt.Sprintf("Invalid outcome %q", outcome), http.StatusBadRequest)
+		return
+	}
+	results, err := tracker.ListScrubs(outcome)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list scrubs: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if results == nil {
+		results = []ScrubResult{}
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+// GET /backups
+// List tracked backups, newest first by default. The total number of matching backups is returned in
+// the X-Total-Count header.
+// Response: 200 OK with a JSON array of backups, 400 Bad Request on invalid input.
+// Query parameters:
+//
+//	target (string, optional): Only list backups of this target.
+//	type (string, optional): Only list backups of this type: full or incremental.
+//	status (string, optional): Only list backups in this status: saved, uploaded or archived.
+//	from (string, optional): Only list backups taken at or after this time, in RFC 3339 format.
+//	to (string, optional): Only list backups taken before this time, in RFC 3339 format.
+//	label (string, optional): Only list backups whose comment contains this text.
+//	remote (string, optional): Only list backups uploaded, queued for upload or streamed to this remote, e.g. onedrive.
+//	sort (string, optional): Sort by backup_time, id, type or status, backup_time by default.
+//	order (string, optional): asc or desc, desc by default.
+//	limit (int, optional): Maximum number of backups, 50 by default.
+//	offset (int, optional): Number of matching backups to skip.
+func HandleListBackups(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := BackupFilter{
+		Target: query.Get("target"),
+		Type:   query.Get("type"),
+		Label:  query.Get("label"),
+		Sort:   cmp.Or(query.Get("sort"), "backup_time"),
+		Limit:  defaultJobListLimit,
+	}
+	var err error
+	if filter.Type != "" && filter.Type != "full" && filter.Type != "incremental" {
+		http.Error(w, fmt.Sprintf("Invalid type %q", filter.Type), http.StatusBadRequest)
+		return
+	}
+	if value := query.Get("status"); value != "" {
+		filter.Status, err = ParseStatus(value)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter.HasStatus = true
+	}
+	for _, param := range []struct {
+		name  string
+		value *time.Time
+	}{
+		{"from", &filter.From},
+		{"to", &filter.To},
+	} {
+		if value := query.Get(param.name); value != "" {
+			*param.value, err = time.Parse(time.RFC3339, value)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Invalid %s: %v", param.name, err), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	if value := query.Get("remote"); value != "" {
+		remote := strings.TrimSuffix(value, ":")
+		if !remoteNameRegexp.MatchString(remote) {
+			http.Error(w, fmt.Sprintf("Invalid remote %q", value), http.StatusBadRequest)
+			return
+		}
+		filter.Remote = remote + ":"
+	}
+	if !slices.Contains(backupSortColumns, filter.Sort) {
+		http.Error(w, fmt.Sprintf("Invalid sort %q, expected one of %s", filter.Sort, strings.Join(backupSortColumns, ", ")), http.StatusBadRequest)
+		return
+	}
+	switch query.Get("order") {
+	case "", "desc":
+		filter.Descending = true
+	case "asc":
+	default:
+		http.Error(w, fmt.Sprintf("Invalid order %q, expected asc or desc", query.Get("order")), http.StatusBadRequest)
+		return
+	}
+	if value := query.Get("limit"); value != "" {
+		filter.Limit, err = strconv.Atoi(value)
+		if err != nil || filter.Limit <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	if value := query.Get("offset"); value != "" {
+		filter.Offset, err = strconv.Atoi(value)
+		if err != nil || filter.Offset < 0 {
+			http.Error(w, "Invalid offset", http.StatusBadRequest)
+			return
+		}
+	}
+
+	tracks, total, err := tracker.ListBackups(filter)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list backups: %v", err), http.StatusInternalServerError)
+		return
+	}
+	backups := []BackupSummary{}
+	for _, track := range tracks {
+		backups = append(backups, newBackupSummary(track))
+	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	writeJSON(w, http.StatusOK, backups)
+}
+
+// GET /backups/{id}
+// Get the full record of a backup: its manifest, binlog coordinates, the backups it is chained with, and
+// the state of its local copy and of its copies on remotes.
+// Response: 200 OK with the backup, 400 Bad Request on invalid ID, 404 Not Found if the backup does not exist.
+func HandleGetBackup(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid backup ID", http.StatusBadRequest)
+		return
+	}
+	track, err := tracker.GetBackup(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Backup not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get backup: %v", err), http.StatusInternalServerError)
+		return
+	}
+	detail, err := newBackupDetail(track)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to describe backup: %v", err), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, detail)
+}
+
+// GET /backups/{id}/replication
+// Get the binlog coordinates and GTID set recorded for a backup, e.g. to seed a new replica from it.
+// Response: 200 OK with the coordinates, 400 Bad Request on invalid ID,
+// 404 Not Found if the backup does not exist or has no recorded coordinates.
+func HandleBackupReplication(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid backup ID", http.StatusBadRequest)
+		return
+	}
+	track, err := tracker.GetBackup(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Backup not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get backup: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if track.Binlog == (BinlogInfo{}) {
+		http.Error(w, fmt.Sprintf("No binlog coordinates recorded for backup #%d", id), http.StatusNotFound)
+		return
+	}
+	type BackupReplicationResponse struct {
+		BackupID   int64     `json:"backup_id"`
+		Target     string    `json:"target"`
+		BackupName string    `json:"backup_name"`
+		BackupType string    `json:"backup_type"`
+		BackupTime time.Time `json:"backup_time"`
+		BinlogInfo
+	}
+	writeJSON(w, http.StatusOK, BackupReplicationResponse{
+		BackupID:   track.ID,
+		Target:     track.Target,
+		BackupName: track.BackupName(),
+		BackupType: track.Type,
+		BackupTime: track.BackupTime,
+		BinlogInfo: track.Binlog,
+	})
+}
+
+// POST /scheduler/pause
+// Pause scheduled jobs. Paused jobs survive restarts; running jobs are not interrupted.
+// Response: 200 OK with the scheduler status on success, 400 Bad Request on invalid input, 500 Internal Server Error on failure.
+// Request body:
+//
+//	job (string, optional): The scheduled job to pause: cleanup, upload, scrub, "<target>/full" or "<target>/incremental",
+//	or full or incremental for all targets. All jobs if omitted.
+//	reason (string, optional): Why the jobs are paused.
+//	resume_at (string, optional): When to resume automatically, in RFC 3339 format.
+//	duration (string, optional): Resume automatically after this duration, e.g. "2h". Ignored if resume_at is set.
+func HandleSchedulerPause(w http.ResponseWriter, r *http.Request) {
+	type SchedulerPauseRequest struct {
+		Job      string `json:"job,omitempty"`
+		Reason   string `json:"reason,omitempty"`
+		ResumeAt string `json:"resume_at,omitempty"`
+		Duration string `json:"duration,omitempty"`
+	}
+	var req SchedulerPauseRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
-	log.Printf("Received download backup request: drive=%s, backup_name=%s", req.Drive, req.BackupName)
-	go func() {
-		err = DownloadFromRClone(req.Drive, req.BackupName)
+	var resumeAt time.Time
+	if req.ResumeAt != "" {
+		resumeAt, err = time.Parse(time.RFC3339, req.ResumeAt)
 		if err != nil {
-			log.Printf("Download backup failed: %v", err)
-		} else {
-			log.Printf("Download backup %s completed successfully.", req.BackupName)
+			http.Error(w, fmt.Sprintf("Invalid resume_at: %v", err), http.StatusBadRequest)
+			return
 		}
-	}()
-	w.WriteHeader(http.StatusNoContent)
+	} else if req.Duration != "" {
+		duration, err := time.ParseDuration(req.Duration)
+		if err != nil || duration <= 0 {
+			http.Error(w, "Invalid duration", http.StatusBadRequest)
+			return
+		}
+		resumeAt = time.Now().Add(duration)
+	}
+	names, err := scheduleNames(req.Job)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("Received scheduler pause request: job=%s, reason=%s, resume_at=%s", req.Job, req.Reason, req.ResumeAt)
+	for _, name := range names {
+		err = PauseSchedule(name, req.Reason, resumeAt)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to pause %s: %v", name, err), http.StatusInternalServerError)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, GetScheduleStatuses())
+}
+
+// POST /scheduler/resume
+// Resume paused scheduled jobs. The interval of a resumed job restarts from now.
+// Response: 200 OK with the scheduler status on success, 400 Bad Request on invalid input, 500 Internal Server Error on failure.
+// Request body:
+//
+//	job (string, optional): The scheduled job to resume. All jobs if omitted.
+func HandleSchedulerResume(w http.ResponseWriter, r *http.Request) {
+	type SchedulerResumeRequest struct {
+		Job string `json:"job,omitempty"`
+	}
+	var req SchedulerResumeRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	names, err := scheduleNames(req.Job)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	log.Printf("Received scheduler resume request: job=%s", req.Job)
+	for _, name := range names {
+		err = ResumeSchedule(name)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to resume %s: %v", name, err), http.StatusInternalServerError)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, GetScheduleStatuses())
+}
+
+// GET /scheduler
+// Get the interval and paused state of each scheduled job.
+// Response: 200 OK with a JSON array of scheduled jobs.
+func HandleSchedulerStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, GetScheduleStatuses())
+}
+
+// GET /health
+// Response: 200 OK with "OK", followed by the paused scheduled jobs if any.
+func HandleHealth(w http.ResponseWriter, r *http.Request) {
+	var paused []string
+	for _, status := range GetScheduleStatuses() {
+		if status.Paused {
+			paused = append(paused, status.Name)
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+	if len(paused) == 0 {
+		w.Write([]byte("OK"))
+		return
+	}
+	w.Write([]byte("OK (paused: " + strings.Join(paused, ", ") + ")"))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
 }
@@ -0,0 +1,158 @@
+// Capture the output of each job in a log file under the data volume.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type jobContextKey struct{}
+
+// Attach a job to a context, so that subprocesses started with it log to the job's log file.
+func withJob(ctx context.Context, job *Job) context.Context {
+	return context.WithValue(ctx, jobContextKey{}, job)
+}
+
+// Get the job a context belongs to, or nil.
+func JobFromContext(ctx context.Context) *Job {
+	job, _ := ctx.Value(jobContextKey{}).(*Job)
+	return job
+}
+
+func FormatJobLogPath(id int64) string {
+	return filepath.Join(config.JobLogDir, fmt.Sprintf("job_%d.log", id))
+}
+
+// Create the log file of a job and record its path in the tracker.
+func OpenJobLog(job *Job) (*os.File, error) {
+	err := os.MkdirAll(config.JobLogDir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	path := FormatJobLogPath(job.ID)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	err = tracker.UpdateJobLogPath(job.ID, path)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// Write a timestamped line to the log file of the job ctx belongs to, if any.
+func JobLogf(ctx context.Context, format string, args ...any) {
+	job := JobFromContext(ctx)
+	if job == nil || job.log == nil {
+		return
+	}
+	fmt.Fprintf(job.log, "[%s] %s\n", time.Now().Format(time.DateTime), fmt.Sprintf(format, args...))
+}
+
+// Format a command line for logs, hiding the values of password arguments.
+func FormatCommand(name string, args []string) string {
+	parts := []string{name}
+	for _, arg := range args {
+		if key, _, ok := strings.Cut(arg, "="); ok && strings.Contains(key, "password") {
+			arg = key + "=***"
+		}
+		parts = append(parts, arg)
+	}
+	return strings.Join(parts, " ")
+}
+
+// A writer keeping only the last lines written to it, used to include the end of a
+// subprocess output in error messages.
+type tailBuffer struct {
+	mu    sync.Mutex
+	buf   []byte
+	lines int
+}
+
+func newTailBuffer(lines int) *tailBuffer {
+	return &tailBuffer{lines: lines}
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf = append(b.buf, p...)
+	for bytes.Count(b.buf, []byte{'\n'}) > b.lines || len(b.buf) > subprocessOutputTailBytes {
+		i := bytes.IndexByte(b.buf, '\n')
+		if i < 0 {
+			b.buf = b.buf[len(b.buf)-subprocessOutputTailBytes:]
+			break
+		}
+		b.buf = b.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.buf)
+}
+
+// Read the last n lines from r, or everything if n is not positive.
+func ReadLastLines(r io.Reader, n int) ([]byte, error) {
+	if n <= 0 {
+		return io.ReadAll(r)
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+		if len(lines) > n {
+			lines = lines[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return nil, nil
+	}
+	return []byte(strings.Join(lines, "\n") + "\n"), nil
+}
+
+// Delete job logs older than the configured retention.
+func CleanupJobLogs() error {
+	entries, err := os.ReadDir(config.JobLogDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	threshold := time.Now().Add(-config.JobLogRetention)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".log") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(threshold) {
+			continue
+		}
+		err = os.Remove(filepath.Join(config.JobLogDir, entry.Name()))
+		if err != nil {
+			log.Printf("Failed to delete job log %s: %v", entry.Name(), err)
+			continue
+		}
+		log.Printf("Deleted job log %s\n", entry.Name())
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+// Resolve where the backups of a target are stored on remotes.
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// The placeholders of remote_path templates.
+var remotePathPlaceholders = []string{"{target}", "{year}", "{month}", "{type}"}
+
+var remotePathPlaceholderRegexp = regexp.MustCompile(`\{[^}]*\}`)
+
+// Check that a remote_path template only uses known placeholders.
+func validateRemotePath(template string) error {
+	for _, placeholder := range remotePathPlaceholderRegexp.FindAllString(template, -1) {
+		if !slices.Contains(remotePathPlaceholders, placeholder) {
+			return fmt.Errorf("invalid remote_path %q: unknown placeholder %s, expected one of %s", template, placeholder, strings.Join(remotePathPlaceholders, ", "))
+		}
+	}
+	return nil
+}
+
+// The layout of the backups of a target on remotes: the directory a backup is stored in is the
+// remote_path template with its placeholders replaced, e.g. "/mysql/{target}/{year}/{month}".
+type RemoteLayout struct {
+	target string
+	// The remote_path template, empty to store backups at the path of the local backup directory as
+	// earlier versions did.
+	template string
+	// The local backup directory of the target, used by the legacy layout.
+	backupDir string
+}
+
+// The remote layout of a target, also for targets removed from the config.
+func targetRemoteLayout(name string) RemoteLayout {
+	if target := GetTarget(name); target != nil {
+		return RemoteLayout{target: name, template: target.RemotePath, backupDir: target.BackupDir}
+	}
+	return RemoteLayout{target: name, template: config.RemotePath, backupDir: targetBackupDir(name)}
+}
+
+// The directory on the remote that holds a backup of the given time and type.
+func (l RemoteLayout) Dir(backupTime time.Time, backupType string) string {
+	if l.template == "" {
+		return path.Clean(l.backupDir)
+	}
+	dir := strings.NewReplacer(
+		"{target}", l.target,
+		"{year}", backupTime.Format("2006"),
+		"{month}", backupTime.Format("01"),
+		"{type}", backupType,
+	).Replace(l.template)
+	return path.Clean("/" + dir)
+}
+
+// The path on the remote of a backup directory or streamed backup object, e.g. db_20251130_1200.
+// Names that are not backup names are rejected, so that they cannot escape the directory.
+func (l RemoteLayout) Path(name string) (string, error) {
+	backupTime, backupType, ok := ParseBackupName(name)
+	if !ok {
+		return "", fmt.Errorf("invalid backup name %q", name)
+	}
+	return path.Join(l.Dir(backupTime, backupType), name), nil
+}
+
+// The directory on the remote below which all backups of the target are stored: the template up to
+// the first path segment that depends on the backup.
+func (l RemoteLayout) Root() string {
+	if l.template == "" {
+		return l.Dir(time.Time{}, "")
+	}
+	root := strings.ReplaceAll(l.template, "{target}", l.target)
+	if i := strings.Index(root, "{"); i >= 0 {
+		root = root[:strings.LastIndex(root[:i], "/")+1]
+	}
+	return path.Clean("/" + root)
+}
+
+// The name of the backup an object below Root belongs to, if the object is part of a backup stored
+// where the layout puts it.
+func (l RemoteLayout) BackupName(object string) (string, bool) {
+	root := l.Root()
+	rel, ok := strings.CutPrefix(object, strings.TrimSuffix(root, "/")+"/")
+	if !ok {
+		return "", false
+	}
+	segments := strings.Split(rel, "/")
+	for i, segment := range segments {
+		backupTime, backupType, ok := ParseBackupName(segment)
+		if ok && l.Dir(backupTime, backupType) == path.Join(append([]string{root}, segments[:i]...)...) {
+			return segment, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestRemoteLayoutPath(t *testing.T) {
+	legacy := RemoteLayout{target: "main", backupDir: "/backup/main/"}
+	templated := RemoteLayout{target: "main", template: "/mysql/{target}/{year}/{month}/{type}"}
+	tests := []struct {
+		layout RemoteLayout
+		name   string
+		want   string
+	}{
+		{legacy, "db_20251130_1200", "/backup/main/db_20251130_1200"},
+		{legacy, "db_20251130_1230_inc.xbstream", "/backup/main/db_20251130_1230_inc.xbstream"},
+		{templated, "db_20251130_1200", "/mysql/main/2025/11/full/db_20251130_1200"},
+		{templated, "db_20251201_0030_inc", "/mysql/main/2025/12/incremental/db_20251201_0030_inc"},
+	}
+	for _, test := range tests {
+		got, err := test.layout.Path(test.name)
+		if err != nil || got != test.want {
+			t.Errorf("Path(%q) = %q, %v, want %q", test.name, got, err, test.want)
+		}
+	}
+
+	for _, name := range []string{"", "../../etc", "db_20251130_1200/../../etc", "db_2025113_1200", "backup.tar"} {
+		for _, layout := range []RemoteLayout{legacy, templated} {
+			got, err := layout.Path(name)
+			if err == nil {
+				t.Errorf("Path(%q) = %q with template %q, want an error", name, got, layout.template)
+			}
+		}
+	}
+}
@@ -0,0 +1,131 @@
+// Store backups in a local directory, e.g. an NFS mount.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+type localStorage struct {
+	root string
+}
+
+func newLocalStorage(remote *RemoteConfig) (*localStorage, error) {
+	root, err := dirPath("path", remote.Path)
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(root, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	return &localStorage{root: root}, nil
+}
+
+// The file of a path, which cannot leave the root directory.
+func (l *localStorage) file(p string) string {
+	return filepath.Join(l.root, filepath.FromSlash(path.Clean("/"+p)))
+}
+
+func (l *localStorage) PutDir(ctx context.Context, localDir string, dir string, throttle Throttle) error {
+	return putDirFiles(ctx, l, localDir, dir, throttle)
+}
+
+func (l *localStorage) GetDir(ctx context.Context, dir string, localDir string, throttle Throttle) error {
+	return getDirFiles(ctx, l, dir, localDir, throttle)
+}
+
+func (l *localStorage) Put(ctx context.Context, p string, r io.Reader, throttle Throttle) error {
+	file := l.file(p)
+	err := os.MkdirAll(filepath.Dir(file), 0o755)
+	if err != nil {
+		return err
+	}
+	partial := file + localPartialSuffix
+	f, err := os.Create(partial)
+	if err != nil {
+		return err
+	}
+	upload, _ := throttle.bandwidth()
+	_, err = io.Copy(f, newRateLimitedReader(ctx, r, upload))
+	if err == nil {
+		err = f.Sync()
+	}
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(partial, file)
+	}
+	if err != nil {
+		os.Remove(partial)
+		return err
+	}
+	return nil
+}
+
+func (l *localStorage) Get(ctx context.Context, p string, throttle Throttle) (io.ReadCloser, error) {
+	f, err := os.Open(l.file(p))
+	if err != nil {
+		return nil, err
+	}
+	_, download := throttle.bandwidth()
+	return readCloser{newRateLimitedReader(ctx, f, download), f}, nil
+}
+
+func (l *localStorage) List(ctx context.Context, dir string) ([]StorageObject, error) {
+	var objects []StorageObject
+	root := l.file(dir)
+	err := filepath.WalkDir(root, func(file string, d fs.DirEntry, err error) error {
+		if file == root && errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() || strings.HasSuffix(file, localPartialSuffix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(l.root, file)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, StorageObject{Path: "/" + filepath.ToSlash(rel), Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+func (l *localStorage) Stat(ctx context.Context, p string) (StorageObject, error) {
+	info, err := os.Stat(l.file(p))
+	if err != nil {
+		return StorageObject{}, err
+	}
+	if !info.Mode().IsRegular() {
+		return StorageObject{}, fmt.Errorf("%s is not a file: %w", p, fs.ErrNotExist)
+	}
+	return StorageObject{Path: path.Clean("/" + p), Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (l *localStorage) Delete(ctx context.Context, p string) error {
+	file := l.file(p)
+	if file == filepath.Clean(l.root) {
+		return fmt.Errorf("refusing to delete the root of the remote")
+	}
+	return os.RemoveAll(file)
+}
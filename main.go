@@ -14,18 +14,41 @@ import (
 func main() {
 	InitializeConfig()
 	InitializeTracker()
+	InitializeRClone()
+	// Recover before anything submits jobs, so that only jobs of the previous run are marked interrupted.
+	RecoverInterruptedJobs()
+	InitializeJobQueue()
 	InitializeJobs()
+	InitializeUploadDispatcher()
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/full", HandleFullBackup)
 	mux.HandleFunc("/incremental", HandleIncrementalBackup)
 	mux.HandleFunc("/download", HandleDownloadBackup)
-	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
-	})
+	mux.HandleFunc("GET /targets", HandleListTargets)
+	mux.HandleFunc("POST /targets/{target}/full", HandleFullBackup)
+	mux.HandleFunc("POST /targets/{target}/incremental", HandleIncrementalBackup)
+	mux.HandleFunc("POST /targets/{target}/download", HandleDownloadBackup)
+	mux.HandleFunc("GET /jobs", HandleListJobs)
+	mux.HandleFunc("GET /jobs/{id}", HandleGetJob)
+	mux.HandleFunc("DELETE /jobs/{id}", HandleCancelJob)
+	mux.HandleFunc("GET /jobs/{id}/events", HandleJobEvents)
+	mux.HandleFunc("GET /jobs/{id}/log", HandleJobLog)
+	mux.HandleFunc("GET /uploads", HandleListUploads)
+	mux.HandleFunc("POST /uploads/{id}/retry", HandleRetryUpload)
+	mux.HandleFunc("GET /backups", HandleListBackups)
+	mux.HandleFunc("GET /backups/{id}", HandleGetBackup)
+	mux.HandleFunc("GET /backups/{id}/replication", HandleBackupReplication)
+	mux.HandleFunc("GET /remotes/{remote}/backups", HandleListRemoteBackups)
+	mux.HandleFunc("POST /remotes/{remote}/rebuild", HandleRebuildCatalog)
+	mux.HandleFunc("POST /scrub", HandleScrub)
+	mux.HandleFunc("GET /scrubs", HandleListScrubs)
+	mux.HandleFunc("POST /scheduler/pause", HandleSchedulerPause)
+	mux.HandleFunc("POST /scheduler/resume", HandleSchedulerResume)
+	mux.HandleFunc("GET /scheduler", HandleSchedulerStatus)
+	mux.HandleFunc("/health", HandleHealth)
 
-	address := ":" + strconv.Itoa(HttpPort)
+	address := ":" + strconv.Itoa(config.HTTPPort)
 	server := &http.Server{
 		Addr:    address,
 		Handler: mux,
@@ -43,6 +66,14 @@ func main() {
 	<-quit
 	log.Println("Shutdown requested...")
 
+	// Stop scheduling new work, then give running jobs time to finish before closing the API,
+	// so that clients waiting for a backup still receive its result.
+	StopJobs()
+	jobCtx, jobCancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	jobQueue.Shutdown(jobCtx)
+	jobCancel()
+	log.Println("Job queue shutdown complete")
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	if err := server.Shutdown(ctx); err != nil {
@@ -51,7 +82,7 @@ func main() {
 		log.Println("HTTP server shutdown complete")
 	}
 
-	StopJobs()
+	StopRClone()
 	tracker.Close()
 	log.Println("Application stopped")
 }
@@ -0,0 +1,290 @@
+// Checksum manifests of backups, and verification of local and remote copies against them.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// How uploaded backups are verified.
+const (
+	// Compare the SHA-256 hashes of the uploaded files, listed by the remote or computed by downloading them.
+	VerifyChecksum = "checksum"
+	// Only compare the names and sizes of the uploaded files.
+	VerifySize = "size"
+	// Do not verify uploads.
+	VerifyOff = "off"
+)
+
+// Wrapped by verification errors of copies that are incomplete or corrupted, as opposed to copies
+// that could not be verified, e.g. because the remote is unreachable.
+var errManifestMismatch = errors.New("manifest mismatch")
+
+// A file of a backup with its size and SHA-256 hash.
+type ManifestEntry struct {
+	// The path of the file relative to the backup directory, or the name of a streamed backup object.
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// The files of a backup, sorted by path.
+type Manifest []ManifestEntry
+
+// Hash the files of a backup directory and write the manifest into it as SHA256SUMS, so that it is
+// uploaded along with the backup and can be checked with sha256sum -c.
+func WriteManifest(ctx context.Context, dir string) (Manifest, error) {
+	files, size, err := localFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	meter := newTransferMeter(ctx, "checksum", size)
+	var manifest Manifest
+	for _, file := range files {
+		if file == manifestFileName {
+			continue
+		}
+		entry, err := hashFile(ctx, filepath.Join(dir, filepath.FromSlash(file)), meter)
+		if err != nil {
+			return nil, err
+		}
+		entry.Path = file
+		manifest = append(manifest, entry)
+		meter.FileDone()
+	}
+	err = os.WriteFile(filepath.Join(dir, manifestFileName), manifest.sha256sums(), 0o644)
+	if err != nil {
+		return nil, err
+	}
+	JobLogf(ctx, "Wrote manifest of %d files to %s", len(manifest), filepath.Join(dir, manifestFileName))
+	return manifest, nil
+}
+
+func hashFile(ctx context.Context, file string, meter *transferMeter) (ManifestEntry, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return ManifestEntry{}, err
+	}
+	defer f.Close()
+	return hashReader(ctx, meter.Reader(f))
+}
+
+// Hash everything read from r.
+func hashReader(ctx context.Context, r io.Reader) (ManifestEntry, error) {
+	h := newManifestHash()
+	_, err := io.Copy(h, r)
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		return ManifestEntry{}, err
+	}
+	return h.Entry(), nil
+}
+
+// Hashes and counts the bytes written to it.
+type manifestHash struct {
+	hash hash.Hash
+	size int64
+}
+
+func newManifestHash() *manifestHash {
+	return &manifestHash{hash: sha256.New()}
+}
+
+func (h *manifestHash) Write(p []byte) (int, error) {
+	h.size += int64(len(p))
+	return h.hash.Write(p)
+}
+
+// The size and hash of the bytes written so far.
+func (h *manifestHash) Entry() ManifestEntry {
+	return ManifestEntry{Size: h.size, SHA256: hex.EncodeToString(h.hash.Sum(nil))}
+}
+
+// The manifest in the format of sha256sum.
+func (m Manifest) sha256sums() []byte {
+	var b bytes.Buffer
+	for _, entry := range m {
+		fmt.Fprintf(&b, "%s  %s\n", entry.SHA256, entry.Path)
+	}
+	return b.Bytes()
+}
+
+// Parse lines in the format of sha256sum into hashes by path. Lines with other hashes, e.g. of rclone
+// for remotes without SHA-256 support, are skipped.
+func parseSHA256Sums(data []byte) map[string]string {
+	sums := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		sum, file, ok := strings.Cut(scanner.Text(), "  ")
+		if ok && len(sum) == sha256.Size*2 && isHex(sum) {
+			sums[file] = strings.ToLower(sum)
+		}
+	}
+	return sums
+}
+
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// Implemented by storages that can list the SHA-256 hashes of objects without downloading them.
+type sha256Lister interface {
+	// The hashes of the objects below the directory path, or of the object at path, by their path
+	// relative to the directory. Objects without a known hash are missing.
+	SHA256Sums(ctx context.Context, path string) (map[string]string, error)
+}
+
+// Check the uploaded copy of a backup against its manifest with the configured verification.
+// dir is the directory on the remote the paths of the manifest are relative to; a streamed backup
+// has a single entry, the object in dir.
+func VerifyUpload(ctx context.Context, remote string, dir string, manifest Manifest, streamed bool) error {
+	if config.UploadVerification == VerifyOff {
+		return nil
+	}
+	ctx, cancel := WithOptionalTimeout(ctx, config.UploadTimeout)
+	defer cancel()
+	return verifyRemoteCopy(ctx, remote, dir, manifest, streamed, config.UploadVerification)
+}
+
+// Check the copy of a backup on a remote against its manifest with the given verification.
+func verifyRemoteCopy(ctx context.Context, remote string, dir string, manifest Manifest, streamed bool, verification string) error {
+	storage := GetStorage(remote)
+	err := verifySizes(ctx, storage, dir, manifest, streamed)
+	if err == nil && verification == VerifyChecksum {
+		err = verifyChecksums(ctx, storage, dir, manifest, streamed)
+	}
+	if err != nil {
+		return fmt.Errorf("Verification of %s%s failed: %w", remote, dir, err)
+	}
+	log.Printf("Verified %d files of %s%s\n", len(manifest), remote, dir)
+	JobLogf(ctx, "Verified %d files of %s%s (%s)", len(manifest), remote, dir, verification)
+	return nil
+}
+
+// Check the local copy of a backup against its manifest by hashing its files.
+func verifyLocalCopy(ctx context.Context, dir string, manifest Manifest) error {
+	var size int64
+	for _, entry := range manifest {
+		size += entry.Size
+	}
+	meter := newTransferMeter(ctx, "verify", size)
+	for _, entry := range manifest {
+		actual, err := hashFile(ctx, filepath.Join(dir, filepath.FromSlash(entry.Path)), meter)
+		if errors.Is(err, fs.ErrNotExist) {
+			err = fmt.Errorf("%w: %s is missing", errManifestMismatch, entry.Path)
+		}
+		if err == nil && (actual.SHA256 != entry.SHA256 || actual.Size != entry.Size) {
+			err = fmt.Errorf("%w: %s has SHA-256 %s and %d bytes, expected %s and %d bytes", errManifestMismatch, entry.Path, actual.SHA256, actual.Size, entry.SHA256, entry.Size)
+		}
+		if err != nil {
+			return fmt.Errorf("Verification of %s failed: %w", dir, err)
+		}
+		meter.FileDone()
+	}
+	log.Printf("Verified %d files of %s\n", len(manifest), dir)
+	JobLogf(ctx, "Verified %d files of %s", len(manifest), dir)
+	return nil
+}
+
+func verifySizes(ctx context.Context, storage Storage, dir string, manifest Manifest, streamed bool) error {
+	sizes := make(map[string]int64)
+	if streamed {
+		for _, entry := range manifest {
+			object, err := storage.Stat(ctx, path.Join(dir, entry.Path))
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			sizes[entry.Path] = object.Size
+		}
+	} else {
+		objects, err := storage.List(ctx, dir)
+		if err != nil {
+			return err
+		}
+		prefix := strings.TrimSuffix(dir, "/") + "/"
+		for _, object := range objects {
+			sizes[strings.TrimPrefix(object.Path, prefix)] = object.Size
+		}
+	}
+	for _, entry := range manifest {
+		size, ok := sizes[entry.Path]
+		if !ok {
+			return fmt.Errorf("%w: %s is missing", errManifestMismatch, entry.Path)
+		}
+		if size != entry.Size {
+			return fmt.Errorf("%w: %s has %d bytes, expected %d", errManifestMismatch, entry.Path, size, entry.Size)
+		}
+	}
+	return nil
+}
+
+// Compare the hashes listed by the remote, and download and hash the files it has no hashes for.
+func verifyChecksums(ctx context.Context, storage Storage, dir string, manifest Manifest, streamed bool) error {
+	var sums map[string]string
+	if lister, ok := storage.(sha256Lister); ok {
+		listPath := dir
+		if streamed && len(manifest) == 1 {
+			listPath = path.Join(dir, manifest[0].Path)
+		}
+		var err error
+		sums, err = lister.SHA256Sums(ctx, listPath)
+		if err != nil {
+			JobLogf(ctx, "The remote cannot list SHA-256 hashes, downloading the backup instead: %v", err)
+		}
+	}
+	var downloads Manifest
+	var size int64
+	for _, entry := range manifest {
+		sum, ok := sums[entry.Path]
+		if !ok {
+			downloads = append(downloads, entry)
+			size += entry.Size
+			continue
+		}
+		if sum != entry.SHA256 {
+			return fmt.Errorf("%w: %s has SHA-256 %s, expected %s", errManifestMismatch, entry.Path, sum, entry.SHA256)
+		}
+	}
+	if len(downloads) == 0 {
+		return nil
+	}
+
+	JobLogf(ctx, "Downloading %d files to verify their checksums", len(downloads))
+	_, download := currentThrottle(ctx).bandwidth()
+	meter := newTransferMeter(ctx, "verify", size)
+	for _, entry := range downloads {
+		object, err := storage.Get(ctx, path.Join(dir, entry.Path), Throttle{})
+		if err != nil {
+			return err
+		}
+		actual, err := hashReader(ctx, meter.Reader(newRateLimitedReader(ctx, object, download)))
+		object.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", entry.Path, err)
+		}
+		if actual.SHA256 != entry.SHA256 || actual.Size != entry.Size {
+			return fmt.Errorf("%w: %s has SHA-256 %s and %d bytes, expected %s and %d bytes", errManifestMismatch, entry.Path, actual.SHA256, actual.Size, entry.SHA256, entry.Size)
+		}
+		meter.FileDone()
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+// Paths and ports of the service, configurable through config.json and environment variables.
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Override settings of config.json with the environment variables that are set.
+func applyEnvOverrides() error {
+	stringSettings := map[string]*string{
+		"MYSQL_USER":          &config.MysqlUser,
+		"MYSQL_HOST":          &config.MysqlHost,
+		"MYSQL_SOCKET":        &config.MysqlSocket,
+		"MYSQL_DEFAULTS_FILE": &config.MysqlDefaultsFile,
+		"MYSQL_SSL_MODE":      &config.MysqlSSLMode,
+		"MYSQL_SSL_CA":        &config.MysqlSSLCA,
+		"MYSQL_SSL_CERT":      &config.MysqlSSLCert,
+		"MYSQL_SSL_KEY":       &config.MysqlSSLKey,
+		"MYSQL_DATADIR":       &config.Datadir,
+		"BACKUP_DIR":          &config.BackupDir,
+		"DOWNLOAD_DIR":        &config.DownloadDir,
+		"DATABASE_PATH":       &config.DatabasePath,
+		"JOB_LOG_DIR":         &config.JobLogDir,
+		"RCLONE_CONFIG":       &config.RCloneConfig,
+	}
+	for env, field := range stringSettings {
+		if value := os.Getenv(env); value != "" {
+			*field = value
+		}
+	}
+	intSettings := map[string]*int{
+		"MYSQL_PORT": &config.MysqlPort,
+		"HTTP_PORT":  &config.HTTPPort,
+	}
+	for env, field := range intSettings {
+		value := os.Getenv(env)
+		if value == "" {
+			continue
+		}
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%s must be a number, got %q", env, value)
+		}
+		*field = number
+	}
+	return nil
+}
+
+// Fill in the default paths and check that they can be used.
+func initializePaths() error {
+	var err error
+	config.Datadir = cmp.Or(config.Datadir, defaultDatadir)
+	config.BackupDir, err = dirPath("backup_dir", cmp.Or(config.BackupDir, defaultBackupDir))
+	if err != nil {
+		return err
+	}
+	config.DownloadDir, err = dirPath("download_dir", cmp.Or(config.DownloadDir, defaultDownloadDir))
+	if err != nil {
+		return err
+	}
+	config.JobLogDir, err = dirPath("job_log_dir", cmp.Or(config.JobLogDir, defaultJobLogDir))
+	if err != nil {
+		return err
+	}
+
+	config.DatabasePath = cmp.Or(config.DatabasePath, defaultDatabasePath)
+	err = os.MkdirAll(filepath.Dir(config.DatabasePath), 0o755)
+	if err != nil {
+		return fmt.Errorf("database_path: %w", err)
+	}
+
+	config.RCloneConfig = cmp.Or(config.RCloneConfig, defaultRCloneConfig)
+	err = checkReadable("rclone_config", config.RCloneConfig)
+	if err != nil {
+		return err
+	}
+
+	config.HTTPPort = cmp.Or(config.HTTPPort, defaultHTTPPort)
+	if config.HTTPPort < 1 || config.HTTPPort > 65535 {
+		return fmt.Errorf("http_port must be between 1 and 65535, got %d", config.HTTPPort)
+	}
+	return nil
+}
+
+// Normalize a directory setting to an absolute path ending in "/". Backup directories must be absolute
+// because without remote_path backups are uploaded to the same path on the remote.
+func dirPath(name string, path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("%s must be an absolute path, got %q", name, path)
+	}
+	return strings.TrimSuffix(filepath.Clean(path), "/") + "/", nil
+}
+
+// Check that a file given by a setting exists and can be read.
+func checkReadable(name string, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s: %s is a directory", name, path)
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+// Parse xtrabackup and rclone output into live progress records of jobs.
+package main
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The live progress of a job.
+type Progress struct {
+	// The operation in progress: backup, upload or download.
+	Phase string `json:"phase"`
+	// Completion in percent, zero if the total is unknown.
+	Percent float64 `json:"percent"`
+	// Bytes copied, compressed or transferred so far.
+	BytesDone int64 `json:"bytes_done"`
+	// Total bytes of the operation, zero if unknown.
+	BytesTotal int64 `json:"bytes_total,omitempty"`
+	// Files completed so far.
+	FilesDone int `json:"files_done,omitempty"`
+	// The last InnoDB log sequence number scanned by xtrabackup.
+	LSN string `json:"lsn,omitempty"`
+	// Current throughput.
+	BytesPerSecond float64 `json:"bytes_per_second,omitempty"`
+	// Estimated time remaining in seconds.
+	ETASeconds int64     `json:"eta_seconds,omitempty"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Record the progress of the job ctx belongs to, if any.
+func ReportProgress(ctx context.Context, progress Progress) {
+	job := JobFromContext(ctx)
+	if job == nil {
+		return
+	}
+	progress.UpdatedAt = time.Now()
+	jobQueue.mu.Lock()
+	job.progress = &progress
+	jobQueue.mu.Unlock()
+}
+
+type outputParserContextKey struct{}
+
+// Attach an output parser to a context. RunSubprocess feeds it every line of output, after the parsers
+// already attached to the context.
+func withOutputParser(ctx context.Context, parse func(line string)) context.Context {
+	if previous := outputParserFromContext(ctx); previous != nil {
+		next := parse
+		parse = func(line string) {
+			previous(line)
+			next(line)
+		}
+	}
+	return context.WithValue(ctx, outputParserContextKey{}, parse)
+}
+
+func outputParserFromContext(ctx context.Context) func(line string) {
+	parse, _ := ctx.Value(outputParserContextKey{}).(func(line string))
+	return parse
+}
+
+// A writer that splits its input into lines, treating carriage returns as line ends.
+type lineWriter struct {
+	buf   []byte
+	parse func(line string)
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := strings.IndexAny(string(w.buf), "\r\n")
+		if i < 0 {
+			break
+		}
+		if i > 0 {
+			w.parse(string(w.buf[:i]))
+		}
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+var (
+	xtrabackupDoneRegexp = regexp.MustCompile(`Done: (?:Copying|Compressing|Compressing and streaming|Streaming|Encrypting|Compressing, encrypting and streaming) (\S+) to`)
+	xtrabackupLSNRegexp  = regexp.MustCompile(`log scanned up to \((\d+)\)`)
+)
+
+// Track xtrabackup progress by summing the sizes of the data files it reports as done.
+type xtrabackupProgress struct {
+	mu         sync.Mutex
+	ctx        context.Context
+	datadir    string
+	started    time.Time
+	progress   Progress
+	lastReport time.Time
+}
+
+// Attach an xtrabackup progress parser to ctx. The total size is estimated from the datadir.
+func WithXtrabackupProgress(ctx context.Context, datadir string) context.Context {
+	p := &xtrabackupProgress{
+		ctx:      ctx,
+		datadir:  datadir,
+		started:  time.Now(),
+		progress: Progress{Phase: "backup", BytesTotal: dirSize(datadir)},
+	}
+	ReportProgress(ctx, p.progress)
+	return withOutputParser(ctx, p.parse)
+}
+
+func (p *xtrabackupProgress) parse(line string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if match := xtrabackupDoneRegexp.FindStringSubmatch(line); match != nil {
+		p.progress.FilesDone++
+		p.progress.BytesDone += fileSize(filepath.Join(p.datadir, match[1]))
+	} else if match := xtrabackupLSNRegexp.FindStringSubmatch(line); match != nil {
+		p.progress.LSN = match[1]
+	} else {
+		return
+	}
+
+	elapsed := time.Since(p.started).Seconds()
+	if elapsed > 0 {
+		p.progress.BytesPerSecond = float64(p.progress.BytesDone) / elapsed
+	}
+	if p.progress.BytesTotal > 0 {
+		p.progress.Percent = min(100, float64(p.progress.BytesDone)*100/float64(p.progress.BytesTotal))
+		if p.progress.BytesPerSecond > 0 {
+			p.progress.ETASeconds = int64(float64(max(0, p.progress.BytesTotal-p.progress.BytesDone)) / p.progress.BytesPerSecond)
+		}
+	}
+	if time.Since(p.lastReport) >= progressReportInterval {
+		p.lastReport = time.Now()
+		ReportProgress(p.ctx, p.progress)
+	}
+}
+
+// Matches rclone --stats-one-line output, e.g. "1.234 GiB / 2.000 GiB, 62%, 10.000 MiB/s, ETA 1m20s".
+var rcloneStatsRegexp = regexp.MustCompile(`([\d.]+ ?[KMGTP]?i?B) / ([\d.]+ ?[KMGTP]?i?B), (\d+)%, ([\d.]+ ?[KMGTP]?i?B)/s, ETA (\S+)`)
+
+// Arguments making rclone print one-line transfer statistics at the default log level.
+var rcloneStatsArgs = []string{"--stats", "5s", "--stats-one-line", "--stats-log-level", "NOTICE"}
+
+// Attach an rclone progress parser to ctx.
+func WithRCloneProgress(ctx context.Context, phase string) context.Context {
+	ReportProgress(ctx, Progress{Phase: phase})
+	return withOutputParser(ctx, func(line string) {
+		match := rcloneStatsRegexp.FindStringSubmatch(line)
+		if match == nil {
+			return
+		}
+		progress := Progress{
+			Phase:          phase,
+			BytesDone:      parseSize(match[1]),
+			BytesTotal:     parseSize(match[2]),
+			BytesPerSecond: float64(parseSize(match[4])),
+		}
+		progress.Percent, _ = strconv.ParseFloat(match[3], 64)
+		if eta, err := time.ParseDuration(match[5]); err == nil {
+			progress.ETASeconds = int64(eta.Seconds())
+		}
+		ReportProgress(ctx, progress)
+	})
+}
+
+// Parse a size such as "1.5 GiB" or "12 B" as printed by rclone.
+func parseSize(s string) int64 {
+	s = strings.ReplaceAll(s, " ", "")
+	units := []struct {
+		suffix     string
+		multiplier float64
+	}{
+		{"PiB", 1 << 50}, {"TiB", 1 << 40}, {"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10},
+		{"PB", 1e15}, {"TB", 1e12}, {"GB", 1e9}, {"MB", 1e6}, {"KB", 1e3}, {"B", 1},
+	}
+	for _, unit := range units {
+		if number, ok := strings.CutSuffix(s, unit.suffix); ok {
+			value, err := strconv.ParseFloat(number, 64)
+			if err != nil {
+				return 0
+			}
+			return int64(value * unit.multiplier)
+		}
+	}
+	return 0
+}
+
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// Sum the sizes of all regular files below dir, zero if it cannot be read.
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,466 @@
+// Run backups, uploads and cleanups through a prioritized job queue. Backups and cleanups are serialized,
+// uploads, downloads and scrubs run on a bounded pool of transfer workers.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+type JobKind int
+
+// Job kinds, declared in priority order: a lower value runs first.
+const (
+	JobFull JobKind = iota
+	JobIncremental
+	JobUpload
+	JobDownload
+	JobCleanup
+	JobScrub
+)
+
+var jobKindNames = []string{"full", "incremental", "upload", "download", "cleanup", "scrub"}
+
+// Jobs of different lanes run concurrently, each lane has its own workers.
+type jobLane int
+
+const (
+	// xtrabackup runs and cleanups, executed one at a time.
+	laneBackup jobLane = iota
+	// Uploads, downloads and scrubs, executed by config.UploadWorkers workers.
+	laneTransfer
+)
+
+func (k JobKind) lane() jobLane {
+	if k == JobUpload || k == JobDownload || k == JobScrub {
+		return laneTransfer
+	}
+	return laneBackup
+}
+
+func (k JobKind) String() string {
+	if int(k) < len(jobKindNames) {
+		return jobKindNames[k]
+	}
+	return fmt.Sprintf("JobKind(%d)", int(k))
+}
+
+func ParseJobKind(s string) (JobKind, error) {
+	for i, name := range jobKindNames {
+		if name == s {
+			return JobKind(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown job kind %q", s)
+}
+
+type JobState int
+
+const (
+	// The job is waiting in the queue.
+	JobQueued JobState = iota
+	// The job is being executed.
+	JobRunning
+	// The job finished without error.
+	JobSucceeded
+	// The job finished with an error.
+	JobFailed
+	// The job was cancelled before it finished.
+	JobCancelled
+	// The job was queued or running when the service stopped.
+	JobInterrupted
+)
+
+var jobStateNames = []string{"queued", "running", "succeeded", "failed", "cancelled", "interrupted"}
+
+func (s JobState) String() string {
+	if int(s) < len(jobStateNames) {
+		return jobStateNames[s]
+	}
+	return fmt.Sprintf("JobState(%d)", int(s))
+}
+
+func ParseJobState(s string) (JobState, error) {
+	for i, name := range jobStateNames {
+		if name == s {
+			return JobState(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown job state %q", s)
+}
+
+// Whether a job in this state will not change anymore.
+func (s JobState) IsFinal() bool {
+	return s == JobSucceeded || s == JobFailed || s == JobCancelled || s == JobInterrupted
+}
+
+var (
+	// ErrJobConflict is returned by Submit when a job with the same key is already queued or running.
+	ErrJobConflict = errors.New("a conflicting job is already queued or running")
+	// ErrJobNotFound is returned by Cancel when no queued or running job has the given ID.
+	ErrJobNotFound = errors.New("job not found or already finished")
+	// ErrShuttingDown is returned by Submit after Shutdown, and is the cancellation cause of interrupted jobs.
+	ErrShuttingDown = errors.New("service is shutting down")
+)
+
+type Job struct {
+	// ID assigned by the tracker when the job is submitted.
+	ID int64
+	// The kind of this job, which also defines its priority.
+	Kind JobKind
+	// Jobs sharing a key are deduplicated, e.g. one upload per backup.
+	Key string
+	// Human readable description used in logs.
+	Description string
+	// The name of the target the job belongs to, empty for jobs of the whole service.
+	Target string
+
+	State      JobState
+	Err        error
+	CreatedAt  time.Time
+	StartedAt  time.Time
+	FinishedAt time.Time
+
+	run      func(ctx context.Context) error
+	log      *os.File
+	progress *Progress
+	ctx      context.Context
+	cancel   context.CancelCauseFunc
+	done     chan struct{}
+}
+
+// Wait blocks until the job has finished and returns its error.
+func (j *Job) Wait() error {
+	<-j.done
+	return j.Err
+}
+
+// The JSON representation of a job.
+type JobInfo struct {
+	ID          int64      `json:"id"`
+	Kind        string     `json:"kind"`
+	Target      string     `json:"target,omitempty"`
+	Description string     `json:"description"`
+	State       string     `json:"state"`
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	Progress    *Progress  `json:"progress,omitempty"`
+}
+
+// Whether the job will not change anymore.
+func (info JobInfo) IsFinished() bool {
+	state, err := ParseJobState(info.State)
+	return err == nil && state.IsFinal()
+}
+
+// Info returns a snapshot of the job.
+func (j *Job) Info() JobInfo {
+	jobQueue.mu.Lock()
+	defer jobQueue.mu.Unlock()
+	info := JobInfo{
+		ID:          j.ID,
+		Kind:        j.Kind.String(),
+		Target:      j.Target,
+		Description: j.Description,
+		State:       j.State.String(),
+		CreatedAt:   j.CreatedAt,
+	}
+	if j.Err != nil {
+		info.Error = j.Err.Error()
+	}
+	if !j.StartedAt.IsZero() {
+		startedAt := j.StartedAt
+		info.StartedAt = &startedAt
+	}
+	if !j.FinishedAt.IsZero() {
+		finishedAt := j.FinishedAt
+		info.FinishedAt = &finishedAt
+	}
+	if j.progress != nil {
+		progress := *j.progress
+		info.Progress = &progress
+	}
+	return info
+}
+
+type JobQueue struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending []*Job
+	running []*Job
+	closed  bool
+}
+
+var jobQueue *JobQueue
+
+func InitializeJobQueue() {
+	jobQueue = &JobQueue{}
+	jobQueue.cond = sync.NewCond(&jobQueue.mu)
+	go jobQueue.worker(laneBackup)
+	for range config.UploadWorkers {
+		go jobQueue.worker(laneTransfer)
+	}
+}
+
+// Create a job that is not yet submitted.
+func NewJob(kind JobKind, key string, description string, run func(ctx context.Context) error) *Job {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	return &Job{
+		Kind:        kind,
+		Key:         key,
+		Description: description,
+		run:         run,
+		ctx:         ctx,
+		cancel:      cancel,
+		done:        make(chan struct{}),
+	}
+}
+
+// Submit adds a job to the queue. If a job with the same key is already queued or running,
+// that job is returned together with ErrJobConflict and the new job is discarded.
+func (q *JobQueue) Submit(job *Job) (*Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		job.cancel(nil)
+		return nil, ErrShuttingDown
+	}
+	for _, active := range slices.Concat(q.running, q.pending) {
+		if active.Key == job.Key {
+			job.cancel(nil)
+			return active, ErrJobConflict
+		}
+	}
+
+	job.State = JobQueued
+	job.CreatedAt = time.Now()
+	err := tracker.TrackJob(job)
+	if err != nil {
+		job.cancel(nil)
+		return nil, err
+	}
+	q.pending = append(q.pending, job)
+	q.cond.Broadcast()
+	log.Printf("Queued job #%d (%s): %s\n", job.ID, job.Kind, job.Description)
+	return job, nil
+}
+
+// Cancel a queued or running job. A queued job is removed from the queue immediately,
+// a running job is asked to stop through its context.
+func (q *JobQueue) Cancel(id int64) (*Job, error) {
+	q.mu.Lock()
+	for _, job := range q.running {
+		if job.ID == id {
+			q.mu.Unlock()
+			log.Printf("Cancelling running job #%d (%s)\n", job.ID, job.Kind)
+			job.cancel(nil)
+			return job, nil
+		}
+	}
+	for i, job := range q.pending {
+		if job.ID == id {
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			q.mu.Unlock()
+			log.Printf("Cancelled queued job #%d (%s)\n", job.ID, job.Kind)
+			job.cancel(nil)
+			q.finish(job, context.Canceled)
+			return job, nil
+		}
+	}
+	q.mu.Unlock()
+	return nil, ErrJobNotFound
+}
+
+// Shutdown stops accepting jobs, marks queued jobs as interrupted and waits for the running jobs
+// until ctx is done. Jobs still running then are cancelled and recorded as interrupted,
+// so they are recovered on the next start.
+func (q *JobQueue) Shutdown(ctx context.Context) {
+	q.mu.Lock()
+	q.closed = true
+	pending := q.pending
+	q.pending = nil
+	running := append([]*Job(nil), q.running...)
+	q.mu.Unlock()
+
+	for _, job := range pending {
+		job.cancel(ErrShuttingDown)
+		q.finish(job, ErrShuttingDown)
+	}
+
+	for _, job := range running {
+		log.Printf("Waiting for running job #%d (%s) to finish...\n", job.ID, job.Kind)
+		select {
+		case <-job.done:
+		case <-ctx.Done():
+			log.Printf("Shutdown deadline reached, cancelling job #%d (%s)\n", job.ID, job.Kind)
+			job.cancel(ErrShuttingDown)
+			<-job.done
+		}
+	}
+}
+
+// Get a queued or running job by ID, or nil if it is not active.
+func (q *JobQueue) Get(id int64) *Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, job := range slices.Concat(q.running, q.pending) {
+		if job.ID == id {
+			return job
+		}
+	}
+	return nil
+}
+
+// Pick the pending job of a lane with the highest priority, oldest first within the same kind.
+func (q *JobQueue) next(lane jobLane) *Job {
+	for {
+		best := -1
+		for i, job := range q.pending {
+			if job.Kind.lane() == lane && (best < 0 || job.Kind < q.pending[best].Kind) {
+				best = i
+			}
+		}
+		if best >= 0 {
+			job := q.pending[best]
+			q.pending = append(q.pending[:best], q.pending[best+1:]...)
+			return job
+		}
+		q.cond.Wait()
+	}
+}
+
+func (q *JobQueue) worker(lane jobLane) {
+	for {
+		q.mu.Lock()
+		job := q.next(lane)
+		job.State = JobRunning
+		job.StartedAt = time.Now()
+		q.running = append(q.running, job)
+		q.mu.Unlock()
+
+		err := tracker.UpdateJob(job)
+		if err != nil {
+			log.Printf("Failed to track job #%d: %v", job.ID, err)
+		}
+		log.Printf("Running job #%d (%s): %s\n", job.ID, job.Kind, job.Description)
+		job.log, err = OpenJobLog(job)
+		if err != nil {
+			log.Printf("Failed to create log file for job #%d: %v", job.ID, err)
+		}
+		ctx := withJob(job.ctx, job)
+		JobLogf(ctx, "Job #%d (%s) started: %s", job.ID, job.Kind, job.Description)
+		err = job.run(ctx)
+		if err != nil && errors.Is(context.Cause(job.ctx), ErrShuttingDown) {
+			err = ErrShuttingDown
+		}
+		job.cancel(nil)
+		q.finish(job, err)
+	}
+}
+
+// Record the outcome of a job and release its waiters.
+func (q *JobQueue) finish(job *Job, err error) {
+	q.mu.Lock()
+	job.Err = err
+	job.FinishedAt = time.Now()
+	switch {
+	case err == nil:
+		job.State = JobSucceeded
+		log.Printf("Job #%d (%s) completed in %s.\n", job.ID, job.Kind, job.FinishedAt.Sub(job.StartedAt).Round(time.Second))
+	case errors.Is(err, ErrShuttingDown):
+		job.State = JobInterrupted
+		log.Printf("Job #%d (%s) was interrupted by shutdown.\n", job.ID, job.Kind)
+	case errors.Is(err, context.Canceled):
+		job.State = JobCancelled
+		log.Printf("Job #%d (%s) was cancelled.\n", job.ID, job.Kind)
+	default:
+		job.State = JobFailed
+		log.Printf("Job #%d (%s) failed: %v\n", job.ID, job.Kind, err)
+	}
+	q.mu.Unlock()
+
+	trackErr := tracker.UpdateJob(job)
+	if trackErr != nil {
+		log.Printf("Failed to track job #%d: %v", job.ID, trackErr)
+	}
+	if job.log != nil {
+		JobLogf(withJob(context.Background(), job), "Job #%d (%s) finished: %s", job.ID, job.Kind, job.State)
+		if err != nil {
+			JobLogf(withJob(context.Background(), job), "Error: %v", err)
+		}
+		job.log.Close()
+	}
+
+	q.mu.Lock()
+	for i, running := range q.running {
+		if running == job {
+			q.running = append(q.running[:i], q.running[i+1:]...)
+			break
+		}
+	}
+	q.mu.Unlock()
+	close(job.done)
+}
+
+// Submit a full backup job of a target, compressed as configured unless compression overrides it.
+func SubmitFullBackup(target *Target, drive string, comment string, compression *Compression) (*Job, error) {
+	settings, err := resolveCompression(target, "full", compression)
+	if err != nil {
+		return nil, err
+	}
+	job := NewJob(JobFull, target.ScheduleName(ScheduleFull), comment, func(ctx context.Context) error {
+		return PerformFullBackup(ctx, target, drive, comment, settings)
+	})
+	job.Target = target.Name
+	return jobQueue.Submit(job)
+}
+
+// Submit an incremental backup job of a target, compressed as configured unless compression overrides it.
+func SubmitIncrementalBackup(target *Target, drive string, comment string, compression *Compression) (*Job, error) {
+	settings, err := resolveCompression(target, "incremental", compression)
+	if err != nil {
+		return nil, err
+	}
+	job := NewJob(JobIncremental, target.ScheduleName(ScheduleIncremental), comment, func(ctx context.Context) error {
+		return PerformIncrementalBackup(ctx, target, drive, comment, settings)
+	})
+	job.Target = target.Name
+	return jobQueue.Submit(job)
+}
+
+// Submit a download job for a backup of a target from remote storage.
+func SubmitDownload(target *Target, remote string, backupName string, keyID string) (*Job, error) {
+	key := "download:" + target.Name + "/" + backupName
+	description := "Download backup " + backupName
+	job := NewJob(JobDownload, key, description, func(ctx context.Context) error {
+		return PerformDownload(ctx, target, remote, backupName, keyID)
+	})
+	job.Target = target.Name
+	return jobQueue.Submit(job)
+}
+
+// Submit a cleanup job for old local and remote backups.
+func SubmitCleanup() (*Job, error) {
+	return jobQueue.Submit(NewJob(JobCleanup, "cleanup", "Cleanup of old local and remote backups", func(ctx context.Context) error {
+		err := CleanupOldBackups()
+		if err != nil {
+			return err
+		}
+		// An unreachable remote does not keep job logs from being cleaned up.
+		return errors.Join(CleanupRemoteBackups(ctx), CleanupJobLogs())
+	}))
+}
+
+// Submit a scrub job verifying backup copies against their manifests.
+func SubmitScrub() (*Job, error) {
+	return jobQueue.Submit(NewJob(JobScrub, "scrub", "Scrub of backup copies", ScrubBackups))
+}
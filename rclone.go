@@ -2,56 +2,232 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
-	"time"
+	"io"
+	"io/fs"
+	"os/exec"
+	"path"
+	"strings"
 )
 
-func UploadToRClone(backupTime time.Time, remote string, isIncremental bool) error {
-	var path string
-	if isIncremental {
-		path = FormatIncrementalBackupDir(backupTime)
-	} else {
-		path = FormatFullBackupDir(backupTime)
+// A remote of rclone.conf, driven through the rclone binary or the remote control API.
+type rcloneStorage struct {
+	// The rclone remote including the colon, e.g. "onedrive:".
+	remote string
+}
+
+func (r *rcloneStorage) PutDir(ctx context.Context, localDir string, path string, throttle Throttle) error {
+	ctx = WithRCloneProgress(ctx, "upload")
+	if rcloneRC != nil {
+		return rcloneRC.Copy(ctx, "upload", localDir, r.remote+path, throttle)
 	}
+	return r.copy(ctx, localDir, r.remote+path, throttle)
+}
 
-	if remote == "" {
-		remote = config.DefaultRCloneRemote
+func (r *rcloneStorage) GetDir(ctx context.Context, path string, localDir string, throttle Throttle) error {
+	ctx = WithRCloneProgress(ctx, "download")
+	if rcloneRC != nil {
+		return rcloneRC.Copy(ctx, "download", r.remote+path, localDir, throttle)
 	}
+	return r.copy(ctx, r.remote+path, localDir, throttle)
+}
+
+func (r *rcloneStorage) copy(ctx context.Context, src string, dst string, throttle Throttle) error {
+	args := append([]string{"copy", src, dst}, throttle.rcloneArgs()...)
+	_, err := runRClone(ctx, append(args, rcloneStatsArgs...)...)
+	return err
+}
 
-	log.Printf("Uploading backup %s to remote %s\n", backupTime.Format(time.DateTime), remote)
-	output, err := RunSubprocess(
-		"rclone",
-		"--config",
-		"rclone.conf",
-		"copy",
-		path,
-		remote+path,
-	)
+func (r *rcloneStorage) Put(ctx context.Context, path string, reader io.Reader, throttle Throttle) error {
+	args := append([]string{"--config", config.RCloneConfig, "rcat", r.remote + path}, throttle.rcloneArgs()...)
+	output, err := RunSubprocessWithInput(ctx, reader, "rclone", args...)
 	if err != nil {
-		return fmt.Errorf("Failed to upload backup to rclone remote: %v, output: %s", err, output)
+		return fmt.Errorf("rclone rcat: %w, output: %s", err, output)
 	}
-	log.Printf("Backup %s uploaded to remote %s successfully.\n", backupTime.Format(time.DateTime), remote)
 	return nil
 }
 
-func DownloadFromRClone(remote string, backupName string) error {
-	if remote == "" {
-		remote = config.DefaultRCloneRemote
+func (r *rcloneStorage) Get(ctx context.Context, path string, throttle Throttle) (io.ReadCloser, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	tail := newTailBuffer(subprocessOutputTailLines)
+	args := append([]string{"--config", config.RCloneConfig, "cat", r.remote + path}, throttle.rcloneArgs()...)
+	cmd := newSubprocess(ctx, tail, "rclone", args...)
+	cmd.Stdout = nil
+	stdout, err := cmd.StdoutPipe()
+	if err == nil {
+		err = cmd.Start()
 	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &rcloneReader{cmd: cmd, stdout: stdout, cancel: cancel, tail: tail}, nil
+}
 
-	log.Printf("Downloading backup %s from remote %s\n", backupName, remote)
-	output, err := RunSubprocess(
-		"rclone",
-		"--config",
-		"rclone.conf",
-		"copy",
-		remote+"/backup/"+backupName,
-		downloadedBackupPath+backupName,
-	)
+func (r *rcloneStorage) List(ctx context.Context, path string) ([]StorageObject, error) {
+	var entries []RCloneEntry
+	var err error
+	if rcloneRC != nil {
+		rcFs, remote := splitRClonePath(r.remote + path)
+		entries, err = rcloneRC.List(ctx, rcFs, remote)
+	} else {
+		err = rcloneJSON(ctx, &entries, "lsjson", "--recursive", "--files-only", r.remote+path)
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
 	if err != nil {
-		return fmt.Errorf("Failed to download backup from rclone remote: %v, output: %s", err, output)
+		return nil, err
 	}
-	log.Printf("Backup %s downloaded from remote %s successfully.\n", backupName, remote)
-	return nil
+	objects := make([]StorageObject, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir {
+			objects = append(objects, StorageObject{Path: joinRemotePath(path, entry.Path), Size: entry.Size, ModTime: entry.ModTime})
+		}
+	}
+	sortObjects(objects)
+	return objects, nil
+}
+
+func (r *rcloneStorage) Stat(ctx context.Context, path string) (StorageObject, error) {
+	var entry *RCloneEntry
+	var err error
+	if rcloneRC != nil {
+		rcFs, remote := splitRClonePath(r.remote + path)
+		entry, err = rcloneRC.Stat(ctx, rcFs, remote)
+	} else {
+		err = rcloneJSON(ctx, &entry, "lsjson", "--stat", r.remote+path)
+	}
+	if err != nil {
+		return StorageObject{}, err
+	}
+	if entry == nil || entry.IsDir {
+		return StorageObject{}, fmt.Errorf("%s%s: %w", r.remote, path, fs.ErrNotExist)
+	}
+	return StorageObject{Path: path, Size: entry.Size, ModTime: entry.ModTime}, nil
+}
+
+func (r *rcloneStorage) Delete(ctx context.Context, path string) error {
+	var err error
+	if rcloneRC != nil {
+		err = rcloneRC.DeleteFile(ctx, r.remote+path)
+		if errors.Is(err, fs.ErrNotExist) {
+			err = rcloneRC.Purge(ctx, r.remote+path)
+		}
+	} else {
+		_, err = runRClone(ctx, "deletefile", r.remote+path)
+		if errors.Is(err, fs.ErrNotExist) {
+			_, err = runRClone(ctx, "purge", r.remote+path)
+		}
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// The SHA-256 hashes as listed by rclone hashsum, if the remote supports them.
+func (r *rcloneStorage) SHA256Sums(ctx context.Context, path string) (map[string]string, error) {
+	if rcloneRC != nil {
+		lines, err := rcloneRC.Hashsum(ctx, r.remote+path, "sha256")
+		if err != nil {
+			return nil, err
+		}
+		return parseSHA256Sums([]byte(strings.Join(lines, "\n"))), nil
+	}
+	output, err := rcloneOutput(ctx, "hashsum", "sha256", r.remote+path)
+	if err != nil {
+		return nil, err
+	}
+	return parseSHA256Sums(output), nil
+}
+
+// Join a path returned by rclone, relative to the listed directory, to the directory.
+func joinRemotePath(dir string, name string) string {
+	return path.Join("/", dir, name)
+}
+
+// Run rclone with the configured rclone.conf. Failures because the object or directory does not exist
+// wrap fs.ErrNotExist.
+func runRClone(ctx context.Context, args ...string) (string, error) {
+	output, err := RunSubprocess(ctx, "rclone", append([]string{"--config", config.RCloneConfig}, args...)...)
+	if err != nil {
+		return output, fmt.Errorf("rclone %s: %w, output: %s", args[0], rcloneError(err), output)
+	}
+	return output, nil
+}
+
+// Run rclone and decode its standard output as JSON into out.
+func rcloneJSON(ctx context.Context, out any, args ...string) error {
+	output, err := rcloneOutput(ctx, args...)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(output, out)
+}
+
+// Run rclone and return its standard output.
+func rcloneOutput(ctx context.Context, args ...string) ([]byte, error) {
+	tail := newTailBuffer(subprocessOutputTailLines)
+	cmd := newSubprocess(ctx, tail, "rclone", append([]string{"--config", config.RCloneConfig}, args...)...)
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("rclone %s: %w, output: %s", args[0], rcloneError(err), tail.String())
+	}
+	return stdout.Bytes(), nil
+}
+
+// Wrap fs.ErrNotExist into the exit errors of rclone for missing directories (3) and files (4).
+func rcloneError(err error) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && (exitErr.ExitCode() == 3 || exitErr.ExitCode() == 4) {
+		return fmt.Errorf("%w (%w)", err, fs.ErrNotExist)
+	}
+	return err
+}
+
+// The output of rclone cat. Reading fails if rclone fails.
+type rcloneReader struct {
+	cmd    *exec.Cmd
+	stdout io.ReadCloser
+	cancel context.CancelFunc
+	tail   *tailBuffer
+	done   bool
+	err    error
+}
+
+func (r *rcloneReader) Read(p []byte) (int, error) {
+	n, err := r.stdout.Read(p)
+	if err == io.EOF {
+		if waitErr := r.wait(); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+// Stop rclone if it is still running.
+func (r *rcloneReader) Close() error {
+	r.cancel()
+	return r.wait()
+}
+
+func (r *rcloneReader) wait() error {
+	if !r.done {
+		r.done = true
+		err := r.cmd.Wait()
+		if err != nil {
+			r.err = fmt.Errorf("rclone cat: %w, output: %s", rcloneError(err), r.tail.String())
+		}
+	}
+	return r.err
 }
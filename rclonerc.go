@@ -0,0 +1,410 @@
+// Drive a long-running rclone rcd through its remote control API.
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+type RCloneRCConfig struct {
+	// Transfer backups through the remote control API instead of one rclone process per transfer.
+	Enabled bool `json:"enabled"`
+	// The URL of an rcd to use, e.g. a sidecar container. If empty, a local rcd is started.
+	URL      string `json:"url"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+	// The address the local rcd listens on.
+	Addr string `json:"addr"`
+}
+
+// A client of the rclone remote control API.
+type RCloneClient struct {
+	url      string
+	user     string
+	password string
+	client   *http.Client
+	// The local rcd started by the service, nil for an external rcd.
+	rcd *exec.Cmd
+
+	// The throttles of the running transfers, combined into the global bandwidth limit of the rcd.
+	bwMu        sync.Mutex
+	bwTransfers map[int64]Throttle
+	bwNextID    int64
+	// The bandwidth limit last set, empty if none was set.
+	bwRate string
+}
+
+// The client used for transfers, nil if the remote control API is disabled.
+var rcloneRC *RCloneClient
+
+func NewRCloneClient(url string, user string, password string) *RCloneClient {
+	return &RCloneClient{
+		url:      strings.TrimSuffix(url, "/"),
+		user:     user,
+		password: password,
+		client:   &http.Client{Timeout: rcloneRCCallTimeout},
+
+		bwTransfers: make(map[int64]Throttle),
+	}
+}
+
+// Connect to the configured rcd, starting a local one if no URL is configured.
+func InitializeRClone() {
+	rc := config.RCloneRC
+	if !rc.Enabled {
+		return
+	}
+	if rc.URL != "" {
+		rcloneRC = NewRCloneClient(rc.URL, rc.User, rc.Password)
+	} else {
+		var err error
+		rcloneRC, err = startLocalRcd(cmp.Or(rc.Addr, defaultRCloneRCAddr))
+		if err != nil {
+			log.Fatalf("Failed to start rclone rcd: %v", err)
+		}
+	}
+	err := rcloneRC.waitReady()
+	if err != nil {
+		log.Fatalf("rclone rcd at %s is not reachable: %v", rcloneRC.url, err)
+	}
+	log.Printf("Using rclone rcd at %s\n", rcloneRC.url)
+}
+
+// Stop the local rcd, if one was started.
+func StopRClone() {
+	if rcloneRC == nil || rcloneRC.rcd == nil {
+		return
+	}
+	rcloneRC.rcd.Process.Signal(syscall.SIGTERM)
+	rcloneRC.rcd.Wait()
+}
+
+// Start rclone rcd with random credentials, passed through the environment to keep them off the command line.
+func startLocalRcd(addr string) (*RCloneClient, error) {
+	secret := make([]byte, 16)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return nil, err
+	}
+	client := NewRCloneClient("http://"+addr, "backup", hex.EncodeToString(secret))
+	cmd := exec.Command("rclone", "rcd", "--config", config.RCloneConfig, "--rc-addr", addr)
+	cmd.Env = append(os.Environ(), "RCLONE_RC_USER="+client.user, "RCLONE_RC_PASS="+client.password)
+	cmd.Stdout = log.Writer()
+	cmd.Stderr = log.Writer()
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	client.rcd = cmd
+	return client, nil
+}
+
+// Wait until the rcd answers, for at most rcloneRCStartTimeout.
+func (c *RCloneClient) waitReady() error {
+	deadline := time.Now().Add(rcloneRCStartTimeout)
+	for {
+		err := c.call(context.Background(), "rc/noop", nil, nil)
+		if err == nil || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(rcloneRCPollInterval)
+	}
+}
+
+// Call a method of the remote control API with the given parameters and decode the result into out.
+func (c *RCloneClient) call(ctx context.Context, method string, params map[string]any, out any) error {
+	if params == nil {
+		params = map[string]any{}
+	}
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url+"/"+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.user != "" {
+		req.SetBasicAuth(c.user, c.password)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var rcErr struct {
+			Error string `json:"error"`
+		}
+		message := resp.Status
+		if json.Unmarshal(data, &rcErr) == nil && rcErr.Error != "" {
+			message = rcErr.Error
+		}
+		if resp.StatusCode == http.StatusNotFound {
+			return fmt.Errorf("rclone %s: %s: %w", method, message, fs.ErrNotExist)
+		}
+		return fmt.Errorf("rclone %s: %s", method, message)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// The state of an asynchronous job, as returned by job/status.
+type RCloneJobStatus struct {
+	ID       int64   `json:"id"`
+	Finished bool    `json:"finished"`
+	Success  bool    `json:"success"`
+	Error    string  `json:"error"`
+	Duration float64 `json:"duration"`
+}
+
+// Transfer statistics, as returned by core/stats.
+type RCloneStats struct {
+	Bytes          int64   `json:"bytes"`
+	TotalBytes     int64   `json:"totalBytes"`
+	Speed          float64 `json:"speed"`
+	ETA            *int64  `json:"eta"`
+	Transfers      int     `json:"transfers"`
+	TotalTransfers int     `json:"totalTransfers"`
+	Errors         int     `json:"errors"`
+}
+
+// An entry of operations/list.
+type RCloneEntry struct {
+	Path    string    `json:"Path"`
+	Name    string    `json:"Name"`
+	Size    int64     `json:"Size"`
+	ModTime time.Time `json:"ModTime"`
+	IsDir   bool      `json:"IsDir"`
+}
+
+func (c *RCloneClient) JobStatus(ctx context.Context, id int64) (RCloneJobStatus, error) {
+	var status RCloneJobStatus
+	err := c.call(ctx, "job/status", map[string]any{"jobid": id}, &status)
+	return status, err
+}
+
+func (c *RCloneClient) StopJob(ctx context.Context, id int64) error {
+	return c.call(ctx, "job/stop", map[string]any{"jobid": id}, nil)
+}
+
+// The transfer statistics of an asynchronous job.
+func (c *RCloneClient) JobStats(ctx context.Context, id int64) (RCloneStats, error) {
+	var stats RCloneStats
+	err := c.call(ctx, "core/stats", map[string]any{"group": fmt.Sprintf("job/%d", id)}, &stats)
+	return stats, err
+}
+
+// List the files below remote of fs recursively, e.g. fs "onedrive:" and remote "backup/main".
+func (c *RCloneClient) List(ctx context.Context, fs string, remote string) ([]RCloneEntry, error) {
+	var result struct {
+		List []RCloneEntry `json:"list"`
+	}
+	opt := map[string]any{"recurse": true, "filesOnly": true}
+	err := c.call(ctx, "operations/list", map[string]any{"fs": fs, "remote": remote, "opt": opt}, &result)
+	return result.List, err
+}
+
+// The entry at remote of fs, nil if there is none.
+func (c *RCloneClient) Stat(ctx context.Context, fs string, remote string) (*RCloneEntry, error) {
+	var result struct {
+		Item *RCloneEntry `json:"item"`
+	}
+	err := c.call(ctx, "operations/stat", map[string]any{"fs": fs, "remote": remote}, &result)
+	return result.Item, err
+}
+
+// Delete a single object, e.g. "onedrive:/backup/db_20251130_1200.xbstream".
+func (c *RCloneClient) DeleteFile(ctx context.Context, object string) error {
+	fs, remote := splitRClonePath(object)
+	return c.call(ctx, "operations/deletefile", map[string]any{"fs": fs, "remote": remote}, nil)
+}
+
+// Delete a directory with all its contents.
+func (c *RCloneClient) Purge(ctx context.Context, dir string) error {
+	fs, remote := splitRClonePath(dir)
+	return c.call(ctx, "operations/purge", map[string]any{"fs": fs, "remote": remote}, nil)
+}
+
+// The hashes of type hashType of the objects below dir, or of the object dir, as lines in the format of
+// sha256sum relative to dir. Fails if the remote does not support the hash type.
+func (c *RCloneClient) Hashsum(ctx context.Context, dir string, hashType string) ([]string, error) {
+	var result struct {
+		Hashsum []string `json:"hashsum"`
+	}
+	err := c.call(ctx, "operations/hashsum", map[string]any{"fs": dir, "hashType": hashType}, &result)
+	return result.Hashsum, err
+}
+
+// Copy a directory from src to dst, both given as rclone paths, as an asynchronous job. Blocks until the
+// job has finished, reporting its progress to the job ctx belongs to. The job is stopped when ctx is done.
+func (c *RCloneClient) Copy(ctx context.Context, phase string, src string, dst string, throttle Throttle) error {
+	transfer, err := c.startTransfer(ctx, throttle)
+	if err != nil {
+		return err
+	}
+	defer c.finishTransfer(ctx, transfer)
+	params := map[string]any{"srcFs": src, "dstFs": dst, "_async": true}
+	if throttle.Transfers > 0 {
+		params["_config"] = map[string]any{"Transfers": throttle.Transfers}
+	}
+	return c.runJob(ctx, phase, "sync/copy", params)
+}
+
+// Register a running transfer and update the bandwidth limit of the rcd to include it.
+func (c *RCloneClient) startTransfer(ctx context.Context, throttle Throttle) (int64, error) {
+	c.bwMu.Lock()
+	defer c.bwMu.Unlock()
+	c.bwNextID++
+	id := c.bwNextID
+	c.bwTransfers[id] = throttle
+	err := c.applyBwLimit(ctx)
+	if err != nil {
+		delete(c.bwTransfers, id)
+		return 0, err
+	}
+	return id, nil
+}
+
+// Unregister a finished transfer and lower the bandwidth limit of the rcd accordingly.
+func (c *RCloneClient) finishTransfer(ctx context.Context, id int64) {
+	c.bwMu.Lock()
+	defer c.bwMu.Unlock()
+	delete(c.bwTransfers, id)
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), rcloneRCCallTimeout)
+	defer cancel()
+	err := c.applyBwLimit(ctx)
+	if err != nil {
+		log.Printf("Failed to update the rclone bandwidth limit: %v", err)
+	}
+}
+
+// Set the bandwidth limit of the rcd, which is global, to the sum of the limits of the running transfers
+// in each direction. A direction is left unlimited while any running transfer is unlimited in it, so that
+// transfer is not throttled by the limits of the others. Must be called with bwMu held.
+func (c *RCloneClient) applyBwLimit(ctx context.Context) error {
+	var upload, download int64
+	uploadLimited, downloadLimited := true, true
+	for _, throttle := range c.bwTransfers {
+		transferUpload, transferDownload := throttle.bandwidth()
+		upload += transferUpload
+		download += transferDownload
+		uploadLimited = uploadLimited && transferUpload > 0
+		downloadLimited = downloadLimited && transferDownload > 0
+	}
+	if !uploadLimited {
+		upload = 0
+	}
+	if !downloadLimited {
+		download = 0
+	}
+	rate := formatBandwidth(upload) + ":" + formatBandwidth(download)
+	if upload == 0 && download == 0 {
+		rate = "off"
+	}
+	if rate == c.bwRate {
+		return nil
+	}
+	err := c.call(ctx, "core/bwlimit", map[string]any{"rate": rate}, nil)
+	if err != nil {
+		return err
+	}
+	c.bwRate = rate
+	return nil
+}
+
+// Start an asynchronous job and wait for it to finish.
+func (c *RCloneClient) runJob(ctx context.Context, phase string, method string, params map[string]any) error {
+	var started struct {
+		JobID int64 `json:"jobid"`
+	}
+	err := c.call(ctx, method, params, &started)
+	if err != nil {
+		return err
+	}
+	JobLogf(ctx, "Started rclone %s job %d: %v", method, started.JobID, params)
+
+	ticker := time.NewTicker(rcloneRCPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			stopCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), rcloneRCCallTimeout)
+			err := c.StopJob(stopCtx, started.JobID)
+			cancel()
+			if err != nil {
+				log.Printf("Failed to stop rclone job %d: %v", started.JobID, err)
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		status, err := c.JobStatus(ctx, started.JobID)
+		if err != nil {
+			if ctx.Err() != nil {
+				continue
+			}
+			return err
+		}
+		stats, err := c.JobStats(ctx, started.JobID)
+		if err == nil {
+			reportRCloneStats(ctx, phase, stats)
+		}
+		if !status.Finished {
+			continue
+		}
+		JobLogf(ctx, "rclone job %d finished after %.0fs", started.JobID, status.Duration)
+		if !status.Success {
+			return fmt.Errorf("rclone %s job %d failed: %s", method, started.JobID, status.Error)
+		}
+		return nil
+	}
+}
+
+func reportRCloneStats(ctx context.Context, phase string, stats RCloneStats) {
+	progress := Progress{
+		Phase:          phase,
+		BytesDone:      stats.Bytes,
+		BytesTotal:     stats.TotalBytes,
+		FilesDone:      stats.Transfers,
+		BytesPerSecond: stats.Speed,
+	}
+	if stats.TotalBytes > 0 {
+		progress.Percent = min(100, float64(stats.Bytes)*100/float64(stats.TotalBytes))
+	}
+	if stats.ETA != nil {
+		progress.ETASeconds = *stats.ETA
+	}
+	ReportProgress(ctx, progress)
+}
+
+// Split an rclone path such as "onedrive:/backup/x" into the remote "onedrive:" and the path on it.
+// Local paths have no remote and are split into "/" and the path.
+func splitRClonePath(path string) (string, string) {
+	if i := strings.Index(path, ":"); i >= 0 && !strings.HasPrefix(path, "/") {
+		return path[:i+1], path[i+1:]
+	}
+	return "/", strings.TrimPrefix(path, "/")
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The rcd the integration tests run against, e.g. started with
+//
+//	rclone rcd --rc-no-auth --rc-addr 127.0.0.1:5572
+//
+// on the same host, since the tests copy between local directories.
+const (
+	rcloneRCTestURLEnv      = "RCLONE_RC_TEST_URL"
+	rcloneRCTestUserEnv     = "RCLONE_RC_TEST_USER"
+	rcloneRCTestPasswordEnv = "RCLONE_RC_TEST_PASSWORD"
+)
+
+func newRCloneTestClient(t *testing.T) *RCloneClient {
+	t.Helper()
+	url := os.Getenv(rcloneRCTestURLEnv)
+	if url == "" {
+		t.Skipf("%s is not set", rcloneRCTestURLEnv)
+	}
+	c := NewRCloneClient(url, os.Getenv(rcloneRCTestUserEnv), os.Getenv(rcloneRCTestPasswordEnv))
+	err := c.call(context.Background(), "rc/noop", nil, nil)
+	if err != nil {
+		t.Fatalf("rcd at %s is not reachable: %v", url, err)
+	}
+	return c
+}
+
+func TestRCloneClientCopyListHashsum(t *testing.T) {
+	c := newRCloneTestClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	src := t.TempDir()
+	dst := filepath.Join(t.TempDir(), "db_20251130_1200")
+	sums := writeBackupTestFiles(t, src)
+
+	err := c.Copy(ctx, "upload", src, dst, Throttle{BwLimit: "10M", Transfers: 2})
+	if err != nil {
+		t.Fatalf("Copy: %v", err)
+	}
+
+	entries, err := c.List(ctx, dst, "")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	var paths []string
+	for _, entry := range entries {
+		paths = append(paths, entry.Path)
+	}
+	slices.Sort(paths)
+	want := []string{"db/users.ibd.zst", "ibdata1.zst", "xtrabackup_checkpoints"}
+	if !slices.Equal(paths, want) {
+		t.Errorf("List = %v, want %v", paths, want)
+	}
+
+	lines, err := c.Hashsum(ctx, dst, "sha256")
+	if err != nil {
+		t.Fatalf("Hashsum: %v", err)
+	}
+	got := parseSHA256Sums([]byte(strings.Join(lines, "\n")))
+	for name, sum := range sums {
+		if got[name] != sum {
+			t.Errorf("Hashsum of %s = %q, want %q", name, got[name], sum)
+		}
+	}
+}
+
+func TestRCloneClientListMissing(t *testing.T) {
+	c := newRCloneTestClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	_, err := c.List(ctx, filepath.Join(t.TempDir(), "missing"), "")
+	if err == nil {
+		t.Fatal("List of a missing directory succeeded")
+	}
+}
+
+func TestRCloneClientBwLimitLiftedAfterCopy(t *testing.T) {
+	c := newRCloneTestClient(t)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	src := t.TempDir()
+	writeBackupTestFiles(t, src)
+
+	err := c.Copy(ctx, "upload", src, t.TempDir(), Throttle{BwLimit: "1M:2M"})
+	if err != nil {
+		t.Fatalf("Copy: %v", err)
+	}
+	var limit struct {
+		Rate string `json:"rate"`
+	}
+	err = c.call(ctx, "core/bwlimit", nil, &limit)
+	if err != nil {
+		t.Fatalf("core/bwlimit: %v", err)
+	}
+	if limit.Rate != "off" {
+		t.Errorf("bandwidth limit after the copy = %q, want off", limit.Rate)
+	}
+}
+
+// A client of an rcd stub that records the rates set with core/bwlimit.
+func newRCloneBwLimitStub(t *testing.T) (*RCloneClient, *[]string) {
+	t.Helper()
+	var rates []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/core/bwlimit" {
+			http.NotFound(w, r)
+			return
+		}
+		var params struct {
+			Rate string `json:"rate"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&params)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rates = append(rates, params.Rate)
+		w.Write([]byte("{}"))
+	}))
+	t.Cleanup(server.Close)
+	return NewRCloneClient(server.URL, "", ""), &rates
+}
+
+func TestRCloneClientApplyBwLimit(t *testing.T) {
+	c, rates := newRCloneBwLimitStub(t)
+	ctx := context.Background()
+
+	first, err := c.startTransfer(ctx, Throttle{BwLimit: "1M"})
+	if err != nil {
+		t.Fatalf("startTransfer: %v", err)
+	}
+	second, err := c.startTransfer(ctx, Throttle{BwLimit: "1M:2M"})
+	if err != nil {
+		t.Fatalf("startTransfer: %v", err)
+	}
+	third, err := c.startTransfer(ctx, Throttle{BwLimit: "off:0"})
+	if err != nil {
+		t.Fatalf("startTransfer: %v", err)
+	}
+	c.finishTransfer(ctx, third)
+	unlimited, err := c.startTransfer(ctx, Throttle{})
+	if err != nil {
+		t.Fatalf("startTransfer: %v", err)
+	}
+	c.finishTransfer(ctx, unlimited)
+	c.finishTransfer(ctx, second)
+	c.finishTransfer(ctx, first)
+
+	want := []string{
+		"1048576b:1048576b",
+		"2097152b:3145728b",
+		"off",
+		"2097152b:3145728b",
+		"off",
+		"2097152b:3145728b",
+		"1048576b:1048576b",
+		"off",
+	}
+	if !slices.Equal(*rates, want) {
+		t.Errorf("rates = %q, want %q", *rates, want)
+	}
+}
+
+func TestRCloneClientApplyBwLimitOneDirection(t *testing.T) {
+	c, rates := newRCloneBwLimitStub(t)
+	ctx := context.Background()
+
+	limited, err := c.startTransfer(ctx, Throttle{BwLimit: "1M:2M"})
+	if err != nil {
+		t.Fatalf("startTransfer: %v", err)
+	}
+	uploadOnly, err := c.startTransfer(ctx, Throttle{BwLimit: "1M:off"})
+	if err != nil {
+		t.Fatalf("startTransfer: %v", err)
+	}
+	c.finishTransfer(ctx, limited)
+	c.finishTransfer(ctx, uploadOnly)
+
+	want := []string{"1048576b:2097152b", "2097152b:off", "1048576b:off", "off"}
+	if !slices.Equal(*rates, want) {
+		t.Errorf("rates = %q, want %q", *rates, want)
+	}
+}
+
+func TestSplitRClonePath(t *testing.T) {
+	tests := []struct {
+		path   string
+		remote string
+		rest   string
+	}{
+		{"onedrive:/backup/db_20251130_1200", "onedrive:", "/backup/db_20251130_1200"},
+		{"onedrive:backup", "onedrive:", "backup"},
+		{"onedrive:", "onedrive:", ""},
+		{"/backup/db_20251130_1200", "/", "backup/db_20251130_1200"},
+		{"/mnt/a:b", "/", "mnt/a:b"},
+	}
+	for _, test := range tests {
+		remote, rest := splitRClonePath(test.path)
+		if remote != test.remote || rest != test.rest {
+			t.Errorf("splitRClonePath(%q) = %q, %q, want %q, %q", test.path, remote, rest, test.remote, test.rest)
+		}
+	}
+}
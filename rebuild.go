@@ -0,0 +1,130 @@
+// Rebuild the tracked backups from the backups found on remotes.
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"slices"
+	"strings"
+)
+
+// Track the backups of a target found on a remote that are unknown to the tracker, e.g. after the
+// tracking database was lost. Backups are found where the layout of the target puts them. Backups whose
+// local directory is gone are tracked as archived; their compression is taken from the file names and
+// their manifest from SHA256SUMS. Encrypted backups are tracked with an unknown key, since the key they
+// were encrypted with may have been rotated since. Returns the backups on the remote, oldest first.
+func RebuildCatalog(ctx context.Context, target *Target, remote string) ([]RemoteBackup, error) {
+	if remote == "" {
+		remote = target.DefaultRCloneRemote
+	}
+	backups, err := ListRemoteBackups(ctx, target, remote)
+	if err != nil {
+		return nil, err
+	}
+	layout := targetRemoteLayout(target.Name)
+	for i, backup := range backups {
+		if backup.Tracked {
+			continue
+		}
+		remotePath, err := layout.Path(backup.Name)
+		if err != nil {
+			return nil, err
+		}
+		track, err := rebuiltTrack(ctx, target, remote, remotePath, backup)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to rebuild backup %s from remote %s: %w", backup.Name, remote, err)
+		}
+		track.ID, err = tracker.TrackBackup(track)
+		if err != nil {
+			return nil, err
+		}
+		if !backup.Streamed {
+			err = tracker.TrackRemoteCopy(track.ID, remote, remotePath)
+			if err != nil {
+				return nil, err
+			}
+		}
+		backups[i].Tracked, backups[i].BackupID = true, track.ID
+		log.Printf("Tracked backup %s found on remote %s%s\n", backup.Name, remote, remotePath)
+		JobLogf(ctx, "Tracked backup %s found on remote %s%s", backup.Name, remote, remotePath)
+	}
+	return backups, nil
+}
+
+// The track of a backup found on a remote.
+func rebuiltTrack(ctx context.Context, target *Target, remote string, remotePath string, backup RemoteBackup) (DatabaseTrack, error) {
+	track := DatabaseTrack{
+		Target:     target.Name,
+		BackupTime: backup.BackupTime,
+		Status:     Archived,
+		Type:       backup.Type,
+		Comment:    "Rebuilt from " + remote,
+	}
+	if backup.Streamed {
+		// The files of a streamed backup are only known once it is extracted, it is decompressed and
+		// decrypted with the key given on download.
+		track.Status = Uploaded
+		track.RemoteObject = remote + remotePath
+		return track, nil
+	}
+	if _, err := os.Stat(track.GetBackupPath()); err == nil {
+		track.Status = Uploaded
+	}
+	storage := GetStorage(remote)
+	objects, err := storage.List(ctx, remotePath)
+	if err != nil {
+		return DatabaseTrack{}, err
+	}
+	track.Compression = "none"
+	prefix := strings.TrimSuffix(remotePath, "/") + "/"
+	for _, object := range objects {
+		name, encrypted := strings.CutSuffix(object.Path, xbcryptSuffix)
+		if encrypted {
+			track.EncryptionKeyUnknown = true
+		}
+		switch path.Ext(name) {
+		case ".zst":
+			track.Compression = "zstd"
+		case ".lz4":
+			track.Compression = "lz4"
+		}
+		track.Manifest = append(track.Manifest, ManifestEntry{Path: strings.TrimPrefix(object.Path, prefix), Size: object.Size})
+	}
+	track.Manifest, err = rebuiltManifest(ctx, storage, remotePath, track.Manifest)
+	return track, err
+}
+
+// Fill in the hashes of the listed files of a backup directory from its SHA256SUMS. The manifest is
+// nil unless every file has a hash.
+func rebuiltManifest(ctx context.Context, storage Storage, remotePath string, files Manifest) (Manifest, error) {
+	if !slices.ContainsFunc(files, func(entry ManifestEntry) bool { return entry.Path == manifestFileName }) {
+		return nil, nil
+	}
+	r, err := storage.Get(ctx, path.Join(remotePath, manifestFileName), Throttle{})
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	sums := parseSHA256Sums(data)
+	var manifest Manifest
+	for _, entry := range files {
+		if entry.Path == manifestFileName {
+			continue
+		}
+		sum, ok := sums[entry.Path]
+		if !ok {
+			return nil, nil
+		}
+		entry.SHA256 = sum
+		manifest = append(manifest, entry)
+	}
+	return manifest, nil
+}
@@ -0,0 +1,99 @@
+// Recover jobs interrupted by a shutdown or a crash.
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Recover interrupted jobs: partial backups and downloads are moved
+// into a quarantine directory so they are neither uploaded nor used as incremental base.
+func RecoverInterruptedJobs() {
+	err := tracker.MarkStaleJobsInterrupted()
+	if err != nil {
+		log.Printf("Failed to mark stale jobs as interrupted: %v", err)
+		return
+	}
+	jobs, err := tracker.GetUnrecoveredJobs()
+	if err != nil {
+		log.Printf("Failed to load interrupted jobs: %v", err)
+		return
+	}
+
+	quarantineBackups := false
+	for _, job := range jobs {
+		log.Printf("Recovering interrupted job #%d (%s): %s\n", job.ID, job.Kind, job.Description)
+		switch job.Kind {
+		case JobFull, JobIncremental:
+			quarantineBackups = true
+		case JobDownload:
+			// Keys are "download:<target>/<name>", or "download:<name>" for jobs of older versions.
+			key := strings.TrimPrefix(job.Key, "download:")
+			name := key[strings.LastIndex(key, "/")+1:]
+			target := GetTarget(job.Target)
+			if target == nil {
+				target = DefaultTarget()
+			}
+			QuarantineDir(target.DownloadDir, DownloadDirName(name))
+		}
+		err := tracker.MarkJobRecovered(job.ID)
+		if err != nil {
+			log.Printf("Failed to mark job #%d as recovered: %v", job.ID, err)
+		}
+	}
+
+	if quarantineBackups {
+		quarantineUntrackedBackups()
+	}
+}
+
+// Quarantine backup directories of all targets that have no tracked backup, i.e. were never completed.
+func quarantineUntrackedBackups() {
+	backups, err := tracker.GetAllBackups()
+	if err != nil {
+		log.Printf("Failed to load tracked backups: %v", err)
+		return
+	}
+	tracked := make(map[string]bool)
+	for _, backup := range backups {
+		tracked[filepath.Clean(backup.GetBackupPath())] = true
+	}
+
+	for _, target := range config.Targets {
+		entries, err := os.ReadDir(target.BackupDir)
+		if err != nil {
+			log.Printf("Failed to list %s: %v", target.BackupDir, err)
+			continue
+		}
+		for _, entry := range entries {
+			path := filepath.Join(target.BackupDir, entry.Name())
+			if entry.IsDir() && strings.HasPrefix(entry.Name(), "db_") && !tracked[path] {
+				QuarantineDir(target.BackupDir, entry.Name())
+			}
+		}
+	}
+}
+
+// Move dir/name into the quarantine directory under dir, if it exists.
+func QuarantineDir(dir string, name string) {
+	source := filepath.Join(dir, name)
+	if _, err := os.Stat(source); err != nil {
+		return
+	}
+	quarantine := filepath.Join(dir, quarantineDirName)
+	err := os.MkdirAll(quarantine, 0o755)
+	if err != nil {
+		log.Printf("Failed to create quarantine directory %s: %v", quarantine, err)
+		return
+	}
+	target := filepath.Join(quarantine, name+"_"+time.Now().Format("20060102_150405"))
+	err = os.Rename(source, target)
+	if err != nil {
+		log.Printf("Failed to quarantine %s: %v", source, err)
+		return
+	}
+	log.Printf("Quarantined partial output %s to %s\n", source, target)
+}
@@ -0,0 +1,253 @@
+// Back up replicas safely and record the binlog coordinates and GTID set of each backup.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Settings for backing up a replica instead of a primary.
+type ReplicaConfig struct {
+	// Back up with --safe-slave-backup and --slave-info, and check the replication lag first.
+	Enabled bool `json:"enabled"`
+	// Refuse to back up if the replica lags further behind, 5m by default, "0" to skip the check.
+	MaxLagStr string `json:"max_lag"`
+	// Seconds xtrabackup waits for open temporary tables to close, xtrabackup's default if zero.
+	SafeSlaveBackupTimeout int `json:"safe_slave_backup_timeout"`
+
+	MaxLag time.Duration `json:"-"`
+}
+
+func (r *ReplicaConfig) normalize() error {
+	r.MaxLag = defaultMaxReplicationLag
+	if r.MaxLagStr != "" {
+		lag, err := time.ParseDuration(r.MaxLagStr)
+		if err != nil || lag < 0 {
+			return fmt.Errorf("invalid max_lag %q", r.MaxLagStr)
+		}
+		r.MaxLag = lag
+	}
+	if r.SafeSlaveBackupTimeout < 0 {
+		return fmt.Errorf("safe_slave_backup_timeout must not be negative")
+	}
+	return nil
+}
+
+// The xtrabackup arguments for backing up a replica.
+func (r *ReplicaConfig) args() []string {
+	if !r.Enabled {
+		return nil
+	}
+	args := []string{"--safe-slave-backup", "--slave-info"}
+	if r.SafeSlaveBackupTimeout > 0 {
+		args = append(args, "--safe-slave-backup-timeout="+strconv.Itoa(r.SafeSlaveBackupTimeout))
+	}
+	return args
+}
+
+// Check that replication of a replica target is running and within the configured lag, and return the
+// host of its source. The source host is empty if the check is disabled.
+func CheckReplicationLag(ctx context.Context, target *Target) (string, error) {
+	if !target.Replica.Enabled || target.Replica.MaxLag == 0 {
+		return "", nil
+	}
+	defaultsFile, removeDefaultsFile, err := writeMysqlDefaultsFile(target)
+	if err != nil {
+		return "", err
+	}
+	defer removeDefaultsFile()
+	output, err := RunSubprocess(ctx, "mysql", mysqlDefaultsArg(target, defaultsFile), "--vertical", "--execute=SHOW REPLICA STATUS")
+	if err != nil {
+		return "", fmt.Errorf("Failed to check replication status: %w, output: %s", err, output)
+	}
+	status := parseVerticalOutput(output)
+	if len(status) == 0 {
+		return "", fmt.Errorf("target %s is configured as replica, but replication is not configured", target.Name)
+	}
+	if status["Replica_IO_Running"] != "Yes" || status["Replica_SQL_Running"] != "Yes" {
+		return "", fmt.Errorf("replication of target %s is not running: IO %s, SQL %s", target.Name, status["Replica_IO_Running"], status["Replica_SQL_Running"])
+	}
+	seconds, err := strconv.Atoi(status["Seconds_Behind_Source"])
+	if err != nil {
+		return "", fmt.Errorf("unknown replication lag of target %s: %q", target.Name, status["Seconds_Behind_Source"])
+	}
+	lag := time.Duration(seconds) * time.Second
+	if lag > target.Replica.MaxLag {
+		return "", fmt.Errorf("replication lag of target %s is %s, more than max_lag %s", target.Name, lag, target.Replica.MaxLag)
+	}
+	JobLogf(ctx, "Replication lag of target %s is %s", target.Name, lag)
+	return status["Source_Host"], nil
+}
+
+// Parse the "Name: value" lines of mysql --vertical output.
+func parseVerticalOutput(output string) map[string]string {
+	values := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		name, value, ok := strings.Cut(strings.TrimSpace(line), ": ")
+		if ok && !strings.HasPrefix(name, "*") {
+			values[name] = value
+		}
+	}
+	return values
+}
+
+// The binlog coordinates of a backup, as reported by xtrabackup. The binlog position and GTID set are those
+// of the backed up instance; the source coordinates are those of its source if it is a replica.
+type BinlogInfo struct {
+	BinlogFile     string `json:"binlog_file,omitempty"`
+	BinlogPosition int64  `json:"binlog_position,omitempty"`
+	GTIDExecuted   string `json:"gtid_executed,omitempty"`
+
+	SourceHost        string `json:"source_host,omitempty"`
+	SourceLogFile     string `json:"source_log_file,omitempty"`
+	SourceLogPosition int64  `json:"source_log_position,omitempty"`
+}
+
+// The metadata files of xtrabackup with the binlog coordinates of a backup.
+const (
+	// e.g. "binlog.000012	157	uuid:1-42", the GTID set may span several lines.
+	binlogInfoFileName = "xtrabackup_binlog_info"
+	// e.g. "CHANGE REPLICATION SOURCE TO SOURCE_LOG_FILE='binlog.000003', SOURCE_LOG_POS=2048;"
+	slaveInfoFileName = "xtrabackup_slave_info"
+	// Written to --extra-lsndir as well, so it is the only one available locally for streamed backups.
+	xtrabackupInfoFileName = "xtrabackup_info"
+)
+
+// The suffixes of metadata files of compressed and encrypted backups.
+var packedMetadataSuffixes = []string{"", ".zst", ".lz4", xbcryptSuffix, ".zst" + xbcryptSuffix, ".lz4" + xbcryptSuffix}
+
+var (
+	// e.g. "binlog_pos = filename 'binlog.000012', position '157', GTID of the last change 'uuid:1-42'"
+	binlogPositionRegexp = regexp.MustCompile(`binlog_pos = filename '([^']*)', position '(\d+)'(?:, GTID of the last change '([^']*)')?`)
+	sourceLogFileRegexp  = regexp.MustCompile(`(?:MASTER|SOURCE)_LOG_FILE='([^']*)'`)
+	sourceLogPosRegexp   = regexp.MustCompile(`(?:MASTER|SOURCE)_LOG_POS=(\d+)`)
+	autoPositionRegexp   = regexp.MustCompile(`(?:MASTER|SOURCE)_AUTO_POSITION=1`)
+)
+
+// Collects the binlog coordinates of a backup from the metadata files xtrabackup wrote.
+type binlogCapture struct {
+	info BinlogInfo
+}
+
+type binlogCaptureKey struct{}
+
+// Attach a capture of the binlog coordinates of the backup taken with ctx to ctx.
+func withBinlogCapture(ctx context.Context) (context.Context, *binlogCapture) {
+	c := &binlogCapture{}
+	return context.WithValue(ctx, binlogCaptureKey{}, c), c
+}
+
+// The coordinates captured, empty if none were found.
+func (c *binlogCapture) Info() BinlogInfo {
+	return c.info
+}
+
+// Read the binlog coordinates of a backup of target from the metadata files in dir into the capture
+// attached to ctx, if any. Missing coordinates are logged as warnings, as the backup itself is usable.
+func captureBinlogInfo(ctx context.Context, target *Target, dir string) {
+	c, ok := ctx.Value(binlogCaptureKey{}).(*binlogCapture)
+	if !ok {
+		return
+	}
+	info, autoPosition, err := readBinlogInfo(ctx, dir)
+	if err != nil {
+		log.Printf("Warning: failed to read the binlog coordinates of backup %s: %v\n", dir, err)
+		JobLogf(ctx, "Warning: failed to read the binlog coordinates: %v", err)
+		return
+	}
+	if info.BinlogFile == "" && info.GTIDExecuted == "" {
+		log.Printf("Warning: no binlog coordinates found in the metadata of backup %s, is binary logging enabled?\n", dir)
+		JobLogf(ctx, "Warning: no binlog coordinates found in the metadata of the backup")
+	}
+	if target.Replica.Enabled && info.SourceLogFile == "" && !autoPosition {
+		log.Printf("Warning: no source coordinates found in the metadata of replica backup %s\n", dir)
+		JobLogf(ctx, "Warning: no source coordinates found in the metadata of the replica backup")
+	}
+	c.info = info
+}
+
+// Read xtrabackup_binlog_info and xtrabackup_slave_info from a backup directory, decrypting and
+// decompressing copies of them if needed. Streamed backups only have xtrabackup_info, which holds the
+// binlog position but not the source coordinates. Also reports whether the replica replicates with
+// GTID auto-positioning, which needs no source coordinates.
+func readBinlogInfo(ctx context.Context, dir string) (BinlogInfo, bool, error) {
+	tempDir, err := os.MkdirTemp("", "xtrabackup-metadata-")
+	if err != nil {
+		return BinlogInfo{}, false, err
+	}
+	defer os.RemoveAll(tempDir)
+	compressed, encrypted := false, false
+	for _, name := range []string{binlogInfoFileName, slaveInfoFileName, xtrabackupInfoFileName} {
+		for _, suffix := range packedMetadataSuffixes {
+			data, err := os.ReadFile(filepath.Join(dir, name+suffix))
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return BinlogInfo{}, false, err
+			}
+			err = os.WriteFile(filepath.Join(tempDir, name+suffix), data, 0o600)
+			if err != nil {
+				return BinlogInfo{}, false, err
+			}
+			encrypted = encrypted || strings.HasSuffix(suffix, xbcryptSuffix)
+			compressed = compressed || strings.HasPrefix(suffix, ".zst") || strings.HasPrefix(suffix, ".lz4")
+			break
+		}
+	}
+	keyID := ""
+	if encrypted {
+		keyID = config.Encryption.KeyID
+	}
+	err = UnpackBackup(ctx, tempDir, keyID, compressed)
+	if err != nil {
+		return BinlogInfo{}, false, err
+	}
+
+	var info BinlogInfo
+	binlogInfo, err := os.ReadFile(filepath.Join(tempDir, binlogInfoFileName))
+	if err == nil {
+		info = parseBinlogInfo(string(binlogInfo))
+	} else if xtrabackupInfo, err := os.ReadFile(filepath.Join(tempDir, xtrabackupInfoFileName)); err == nil {
+		if match := binlogPositionRegexp.FindStringSubmatch(string(xtrabackupInfo)); match != nil {
+			info.BinlogFile = match[1]
+			info.BinlogPosition, _ = strconv.ParseInt(match[2], 10, 64)
+			info.GTIDExecuted = strings.Join(strings.Fields(match[3]), "")
+		}
+	}
+	autoPosition := false
+	slaveInfo, err := os.ReadFile(filepath.Join(tempDir, slaveInfoFileName))
+	if err == nil {
+		autoPosition = autoPositionRegexp.Match(slaveInfo)
+		if match := sourceLogFileRegexp.FindSubmatch(slaveInfo); match != nil {
+			info.SourceLogFile = string(match[1])
+		}
+		if match := sourceLogPosRegexp.FindSubmatch(slaveInfo); match != nil {
+			info.SourceLogPosition, _ = strconv.ParseInt(string(match[1]), 10, 64)
+		}
+	}
+	return info, autoPosition, nil
+}
+
+// Parse xtrabackup_binlog_info: the binlog file, position and GTID set separated by tabs.
+func parseBinlogInfo(content string) BinlogInfo {
+	var info BinlogInfo
+	fields := strings.SplitN(strings.TrimSpace(content), "\t", 3)
+	info.BinlogFile = fields[0]
+	if len(fields) > 1 {
+		info.BinlogPosition, _ = strconv.ParseInt(strings.TrimSpace(fields[1]), 10, 64)
+	}
+	if len(fields) > 2 {
+		info.GTIDExecuted = strings.Join(strings.Fields(fields[2]), "")
+	}
+	return info
+}
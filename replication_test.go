@@ -0,0 +1,110 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestParseBinlogInfo(t *testing.T) {
+	tests := []struct {
+		content string
+		want    BinlogInfo
+	}{
+		{"binlog.000012\t157\n", BinlogInfo{BinlogFile: "binlog.000012", BinlogPosition: 157}},
+		{
+			"binlog.000012\t157\t3e11fa47-71ca-11e1-9e33-c80aa9429562:1-42\n",
+			BinlogInfo{BinlogFile: "binlog.000012", BinlogPosition: 157, GTIDExecuted: "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-42"},
+		},
+		{
+			"mysql-bin.000003\t2048\t3e11fa47-71ca-11e1-9e33-c80aa9429562:1-42,\n8a94f357-aab4-11df-86ab-c80aa9429562:1-5\n",
+			BinlogInfo{
+				BinlogFile:     "mysql-bin.000003",
+				BinlogPosition: 2048,
+				GTIDExecuted:   "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-42,8a94f357-aab4-11df-86ab-c80aa9429562:1-5",
+			},
+		},
+	}
+	for _, test := range tests {
+		got := parseBinlogInfo(test.content)
+		if got != test.want {
+			t.Errorf("parseBinlogInfo(%q) = %+v, want %+v", test.content, got, test.want)
+		}
+	}
+}
+
+func TestBinlogPositionRegexp(t *testing.T) {
+	xtrabackupInfo := "tool_version = 8.0.35-30\n" +
+		"binlog_pos = filename 'binlog.000012', position '157', GTID of the last change '3e11fa47-71ca-11e1-9e33-c80aa9429562:1-42,\n" +
+		"8a94f357-aab4-11df-86ab-c80aa9429562:1-5'\n" +
+		"innodb_from_lsn = 0\n"
+	match := binlogPositionRegexp.FindStringSubmatch(xtrabackupInfo)
+	if match == nil {
+		t.Fatalf("binlogPositionRegexp does not match %q", xtrabackupInfo)
+	}
+	if match[1] != "binlog.000012" || match[2] != "157" {
+		t.Errorf("binlog position = %q %q, want binlog.000012 157", match[1], match[2])
+	}
+	if match[3] != "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-42,\n8a94f357-aab4-11df-86ab-c80aa9429562:1-5" {
+		t.Errorf("GTID set = %q", match[3])
+	}
+
+	match = binlogPositionRegexp.FindStringSubmatch("binlog_pos = filename 'binlog.000012', position '157'\n")
+	if match == nil || match[1] != "binlog.000012" || match[2] != "157" || match[3] != "" {
+		t.Errorf("binlog position without a GTID set = %q", match)
+	}
+}
+
+func TestParseVerticalOutput(t *testing.T) {
+	output := "*************************** 1. row ***************************\n" +
+		"             Replica_IO_State: Waiting for source to send event\n" +
+		"                  Source_Host: 10.0.0.1\n" +
+		"           Replica_IO_Running: Yes\n" +
+		"          Replica_SQL_Running: Yes\n" +
+		"                   Last_Error: \n" +
+		"        Seconds_Behind_Source: 3\n"
+	values := parseVerticalOutput(output)
+	want := map[string]string{
+		"Replica_IO_State":      "Waiting for source to send event",
+		"Source_Host":           "10.0.0.1",
+		"Replica_IO_Running":    "Yes",
+		"Replica_SQL_Running":   "Yes",
+		"Seconds_Behind_Source": "3",
+	}
+	for name, value := range want {
+		if values[name] != value {
+			t.Errorf("%s = %q, want %q", name, values[name], value)
+		}
+	}
+	if len(values) != len(want) {
+		t.Errorf("parseVerticalOutput = %v, want %v", values, want)
+	}
+}
+
+func TestSlaveInfoRegexps(t *testing.T) {
+	tests := []struct {
+		slaveInfo    string
+		file         string
+		position     int64
+		autoPosition bool
+	}{
+		{"CHANGE MASTER TO MASTER_LOG_FILE='mysql-bin.000003', MASTER_LOG_POS=2048;\n", "mysql-bin.000003", 2048, false},
+		{"CHANGE REPLICATION SOURCE TO SOURCE_LOG_FILE='binlog.000003', SOURCE_LOG_POS=2048;\n", "binlog.000003", 2048, false},
+		{"SET GLOBAL gtid_purged='3e11fa47-71ca-11e1-9e33-c80aa9429562:1-42';\nCHANGE MASTER TO MASTER_AUTO_POSITION=1;\n", "", 0, true},
+		{"SET GLOBAL gtid_purged='3e11fa47-71ca-11e1-9e33-c80aa9429562:1-42';\nCHANGE REPLICATION SOURCE TO SOURCE_AUTO_POSITION=1;\n", "", 0, true},
+	}
+	for _, test := range tests {
+		var file string
+		var position int64
+		if match := sourceLogFileRegexp.FindStringSubmatch(test.slaveInfo); match != nil {
+			file = match[1]
+		}
+		if match := sourceLogPosRegexp.FindStringSubmatch(test.slaveInfo); match != nil {
+			position, _ = strconv.ParseInt(match[1], 10, 64)
+		}
+		autoPosition := autoPositionRegexp.MatchString(test.slaveInfo)
+		if file != test.file || position != test.position || autoPosition != test.autoPosition {
+			t.Errorf("%q: file %q, position %d, auto-position %v, want %q, %d, %v",
+				test.slaveInfo, file, position, autoPosition, test.file, test.position, test.autoPosition)
+		}
+	}
+}
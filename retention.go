@@ -0,0 +1,156 @@
+// Delete old backups from remotes.
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+)
+
+// Delete the backups of all targets that exceed their remote backup count from every remote the target
+// uploaded or streamed backups to. Backups are found where the layout of the target puts them; only copies
+// the tracker recorded on the remote are counted and deleted, since other backups below the same directory
+// may belong to another service sharing the remote.
+func CleanupRemoteBackups(ctx context.Context) error {
+	var errs []error
+	for _, target := range config.Targets {
+		if target.RemoteBackupCount == 0 {
+			continue
+		}
+		remotes, err := targetRemotes(target)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, remote := range remotes {
+			err := cleanupRemote(ctx, target, remote)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("remote retention of %s on %s: %w", target.Name, remote, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// The remotes holding backups of a target: its default remote and the remotes it uploaded or streamed
+// backups to.
+func targetRemotes(target *Target) ([]string, error) {
+	remotes := []string{target.DefaultRCloneRemote}
+	uploadRemotes, err := tracker.GetUploadRemotes(target.Name)
+	if err != nil {
+		return nil, err
+	}
+	for _, remote := range uploadRemotes {
+		remotes = append(remotes, cmp.Or(remote, config.DefaultRCloneRemote))
+	}
+	tracks, err := tracker.GetTargetBackups(target.Name)
+	if err != nil {
+		return nil, err
+	}
+	for _, track := range tracks {
+		if track.IsStreamed() {
+			remote, _ := splitRemoteObject(track.RemoteObject)
+			remotes = append(remotes, remote)
+		}
+	}
+	slices.Sort(remotes)
+	return slices.Compact(remotes), nil
+}
+
+// Keep the newest backup chains of a target on a remote and delete the older ones. A chain is a full
+// backup with the incremental backups taken after it; chains with a backup whose upload is pending,
+// running or dead are kept.
+func cleanupRemote(ctx context.Context, target *Target, remote string) error {
+	listCtx, cancel := context.WithTimeout(ctx, remoteListTimeout)
+	backups, err := ListRemoteBackups(listCtx, target, remote)
+	cancel()
+	if err != nil {
+		return err
+	}
+	var recorded []RemoteBackup
+	for _, backup := range backups {
+		if !backup.Tracked {
+			continue
+		}
+		ok, err := tracker.HasRemoteCopy(backup.BackupID, remote)
+		if err != nil {
+			return err
+		}
+		if ok {
+			recorded = append(recorded, backup)
+		}
+	}
+	chains := remoteBackupChains(recorded)
+	if len(chains) <= target.RemoteBackupCount {
+		return nil
+	}
+	layout := targetRemoteLayout(target.Name)
+	var errs []error
+	for _, chain := range chains[:len(chains)-target.RemoteBackupCount] {
+		undelivered, err := hasUndeliveredUpload(chain)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if undelivered {
+			log.Printf("Keeping backup %s on %s until its chain is uploaded\n", chain[0].Name, remote)
+			continue
+		}
+		// Newest first, so that a failed deletion never leaves incremental backups without the backups
+		// they are based on.
+		for _, backup := range slices.Backward(chain) {
+			err := deleteRemoteBackup(ctx, layout, remote, backup)
+			if err != nil {
+				errs = append(errs, err)
+				break
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Group backups listed oldest first into chains, each starting at a full backup. Incremental backups
+// older than the first full backup belong to the first chain.
+func remoteBackupChains(backups []RemoteBackup) [][]RemoteBackup {
+	var chains [][]RemoteBackup
+	for _, backup := range backups {
+		if len(chains) == 0 || (backup.Type == "full" && hasFullBackup(chains[len(chains)-1])) {
+			chains = append(chains, nil)
+		}
+		chains[len(chains)-1] = append(chains[len(chains)-1], backup)
+	}
+	return chains
+}
+
+func hasFullBackup(chain []RemoteBackup) bool {
+	return slices.ContainsFunc(chain, func(backup RemoteBackup) bool { return backup.Type == "full" })
+}
+
+// Whether a backup of a chain has an upload that is pending, running or dead.
+func hasUndeliveredUpload(chain []RemoteBackup) (bool, error) {
+	for _, backup := range chain {
+		undelivered, err := tracker.HasUndeliveredUpload(backup.BackupID)
+		if err != nil || undelivered {
+			return undelivered, err
+		}
+	}
+	return false, nil
+}
+
+// Delete a backup from the remote where the layout puts it and record the deletion.
+func deleteRemoteBackup(ctx context.Context, layout RemoteLayout, remote string, backup RemoteBackup) error {
+	remotePath, err := layout.Path(backup.Name)
+	if err != nil {
+		return err
+	}
+	err = GetStorage(remote).Delete(ctx, remotePath)
+	if err != nil {
+		return fmt.Errorf("Failed to delete backup %s from remote %s: %w", backup.Name, remote, err)
+	}
+	log.Printf("Deleted backup %s from remote %s%s\n", backup.Name, remote, remotePath)
+	JobLogf(ctx, "Deleted backup %s from remote %s%s", backup.Name, remote, remotePath)
+	return tracker.MarkRemoteCopyDeleted(backup.BackupID, remote, remotePath)
+}
@@ -0,0 +1,421 @@
+// Store backups in S3-compatible object storage such as AWS S3 or MinIO, signing requests with AWS Signature Version 4.
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type s3Storage struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	prefix    string
+	accessKey string
+	secretKey string
+	pathStyle bool
+	client    *http.Client
+}
+
+func newS3Storage(remote *RemoteConfig) (*s3Storage, error) {
+	if remote.Bucket == "" {
+		return nil, fmt.Errorf("bucket is required")
+	}
+	region := cmp.Or(remote.Region, defaultS3Region)
+	endpoint, err := url.Parse(cmp.Or(remote.Endpoint, "https://s3."+region+".amazonaws.com"))
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid endpoint %q", remote.Endpoint)
+	}
+	s := &s3Storage{
+		endpoint:  endpoint,
+		region:    region,
+		bucket:    remote.Bucket,
+		accessKey: cmp.Or(remote.AccessKeyID, os.Getenv("AWS_ACCESS_KEY_ID")),
+		secretKey: cmp.Or(remote.SecretAccessKey, os.Getenv("AWS_SECRET_ACCESS_KEY")),
+		pathStyle: remote.PathStyle,
+		client:    &http.Client{},
+	}
+	if s.accessKey == "" || s.secretKey == "" {
+		return nil, fmt.Errorf("access_key_id and secret_access_key are required")
+	}
+	if prefix := strings.Trim(remote.Prefix, "/"); prefix != "" {
+		s.prefix = prefix + "/"
+	}
+	return s, nil
+}
+
+// The key of the object at a path.
+func (s *s3Storage) key(p string) string {
+	return s.prefix + strings.TrimPrefix(path.Clean("/"+p), "/")
+}
+
+// The path of the object with a key.
+func (s *s3Storage) path(key string) string {
+	return "/" + strings.TrimPrefix(key, s.prefix)
+}
+
+func (s *s3Storage) PutDir(ctx context.Context, localDir string, dir string, throttle Throttle) error {
+	return putDirFiles(ctx, s, localDir, dir, throttle)
+}
+
+func (s *s3Storage) GetDir(ctx context.Context, dir string, localDir string, throttle Throttle) error {
+	return getDirFiles(ctx, s, dir, localDir, throttle)
+}
+
+// Upload small objects in one request and larger ones, including streams of unknown size, as multipart
+// uploads. Parts are buffered in memory; their size doubles every s3PartSizeDoubling parts, so that the
+// s3MaxParts parts S3 allows hold about 1 TiB.
+func (s *s3Storage) Put(ctx context.Context, p string, r io.Reader, throttle Throttle) error {
+	key := s.key(p)
+	upload, _ := throttle.bandwidth()
+	r = newRateLimitedReader(ctx, r, upload)
+
+	var uploadID string
+	var parts []s3CompletedPart
+	var part bytes.Buffer
+	for done := false; !done; {
+		size := s3MinPartSize << (len(parts) / s3PartSizeDoubling)
+		part.Reset()
+		n, err := io.CopyN(&part, r, int64(size))
+		if err == io.EOF {
+			done = true
+		} else if err != nil {
+			s.abortUpload(ctx, key, uploadID)
+			return err
+		}
+		if uploadID == "" && done {
+			return s.putObject(ctx, key, part.Bytes())
+		}
+		if uploadID == "" {
+			uploadID, err = s.createUpload(ctx, key)
+			if err != nil {
+				return err
+			}
+		}
+		if n == 0 {
+			break
+		}
+		if len(parts) == s3MaxParts {
+			s.abortUpload(ctx, key, uploadID)
+			return fmt.Errorf("object %s exceeds the maximum size of a multipart upload", key)
+		}
+		etag, err := s.uploadPart(ctx, key, uploadID, len(parts)+1, part.Bytes())
+		if err != nil {
+			s.abortUpload(ctx, key, uploadID)
+			return err
+		}
+		parts = append(parts, s3CompletedPart{PartNumber: len(parts) + 1, ETag: etag})
+	}
+	err := s.completeUpload(ctx, key, uploadID, parts)
+	if err != nil {
+		s.abortUpload(ctx, key, uploadID)
+		return err
+	}
+	return nil
+}
+
+func (s *s3Storage) putObject(ctx context.Context, key string, body []byte) error {
+	resp, err := s.do(ctx, http.MethodPut, key, nil, body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *s3Storage) createUpload(ctx context.Context, key string) (string, error) {
+	resp, err := s.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return "", fmt.Errorf("invalid CreateMultipartUpload response: %w", err)
+	}
+	return result.UploadID, nil
+}
+
+func (s *s3Storage) uploadPart(ctx context.Context, key string, uploadID string, number int, body []byte) (string, error) {
+	query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+	resp, err := s.do(ctx, http.MethodPut, key, query, body)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return resp.Header.Get("ETag"), nil
+}
+
+type s3CompletedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+func (s *s3Storage) completeUpload(ctx context.Context, key string, uploadID string, parts []s3CompletedPart) error {
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+		Parts   []s3CompletedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadID}}, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// S3 may report a failure to complete the upload in the body of a 200 OK response.
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	return s3ResponseError(resp.Status, data)
+}
+
+// Abort a multipart upload, if one was started, so that its parts are not kept. Errors are ignored,
+// buckets should additionally expire incomplete multipart uploads.
+func (s *s3Storage) abortUpload(ctx context.Context, key string, uploadID string) {
+	if uploadID == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), remoteCleanupTimeout)
+	defer cancel()
+	resp, err := s.do(ctx, http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil)
+	if err == nil {
+		resp.Body.Close()
+	}
+}
+
+func (s *s3Storage) Get(ctx context.Context, p string, throttle Throttle) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, s.key(p), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	_, download := throttle.bandwidth()
+	return readCloser{newRateLimitedReader(ctx, resp.Body, download), resp.Body}, nil
+}
+
+func (s *s3Storage) List(ctx context.Context, dir string) ([]StorageObject, error) {
+	prefix := s.key(dir)
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	var objects []StorageObject
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	for {
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+			Contents              []struct {
+				Key          string    `xml:"Key"`
+				LastModified time.Time `xml:"LastModified"`
+				Size         int64     `xml:"Size"`
+			} `xml:"Contents"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid ListObjectsV2 response: %w", err)
+		}
+		for _, object := range result.Contents {
+			objects = append(objects, StorageObject{Path: s.path(object.Key), Size: object.Size, ModTime: object.LastModified})
+		}
+		if !result.IsTruncated {
+			break
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+	sortObjects(objects)
+	return objects, nil
+}
+
+func (s *s3Storage) Stat(ctx context.Context, p string) (StorageObject, error) {
+	resp, err := s.do(ctx, http.MethodHead, s.key(p), nil, nil)
+	if err != nil {
+		return StorageObject{}, err
+	}
+	resp.Body.Close()
+	object := StorageObject{Path: s.path(s.key(p)), Size: resp.ContentLength}
+	object.ModTime, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	return object, nil
+}
+
+// Delete the object at the path and all objects below it. S3 has no directories, so both are tried.
+func (s *s3Storage) Delete(ctx context.Context, p string) error {
+	if s.key(p) == s.prefix {
+		return fmt.Errorf("refusing to delete the root of the remote")
+	}
+	objects, err := s.List(ctx, p)
+	if err != nil {
+		return err
+	}
+	keys := []string{s.key(p)}
+	for _, object := range objects {
+		keys = append(keys, s.key(object.Path))
+	}
+	for _, key := range keys {
+		resp, err := s.do(ctx, http.MethodDelete, key, nil, nil)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+	}
+	return nil
+}
+
+// Send a signed request for an object, or for the bucket if key is empty. Responses other than 2xx
+// are returned as errors; 404 Not Found wraps fs.ErrNotExist.
+func (s *s3Storage) do(ctx context.Context, method string, key string, query url.Values, body []byte) (*http.Response, error) {
+	u := *s.endpoint
+	objectPath := "/" + key
+	if s.pathStyle {
+		objectPath = "/" + s.bucket + objectPath
+	} else {
+		u.Host = s.bucket + "." + u.Host
+	}
+	u.Path = strings.TrimSuffix(s.endpoint.Path, "/") + objectPath
+	u.RawPath = s3Escape(strings.TrimSuffix(s.endpoint.Path, "/"), false) + s3Escape(objectPath, false)
+	u.RawQuery = s3CanonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	if body == nil {
+		req.Body = http.NoBody
+	}
+	s.sign(req, hexSHA256(body), time.Now())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	err = s3ResponseError(resp.Status, data)
+	if err == nil {
+		err = fmt.Errorf("S3 %s %s: %s", method, key, resp.Status)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w (%w)", err, fs.ErrNotExist)
+	}
+	return nil, err
+}
+
+// The error in an S3 response body, nil if there is none.
+func s3ResponseError(status string, data []byte) error {
+	var s3Err struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+	}
+	if xml.Unmarshal(data, &s3Err) != nil {
+		return nil
+	}
+	return fmt.Errorf("S3 error %s: %s (%s)", s3Err.Code, s3Err.Message, status)
+}
+
+// Sign a request with AWS Signature Version 4. Signs the host, Content-MD5, Content-Type and Range
+// headers and all x-amz-* headers.
+func (s *s3Storage) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": cmp.Or(req.Host, req.URL.Host)}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") || name == "content-md5" || name == "content-type" || name == "range" {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := slices.Sorted(maps.Keys(headers))
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		s3CanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hexSHA256([]byte(canonicalRequest))
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// The query string in canonical form: sorted by name and escaped as S3 expects.
+func s3CanonicalQuery(query url.Values) string {
+	var params []string
+	for _, name := range slices.Sorted(maps.Keys(query)) {
+		for _, value := range query[name] {
+			params = append(params, s3Escape(name, true)+"="+s3Escape(value, true))
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// Percent-encode all bytes except unreserved characters, and slashes unless escapeSlash is set.
+func s3Escape(s string, escapeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !escapeSlash) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
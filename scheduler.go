@@ -2,99 +2,347 @@
 package main
 
 import (
+	"fmt"
 	"log"
+	"sync"
 	"time"
 )
 
-var (
-	fullBackupTicker        *time.Ticker
-	incrementalBackupTicker *time.Ticker
-	cleanupTicker           *time.Ticker
-	rcloneUploadTicker      *time.Ticker
-)
-
-func fullBackupJob() {
-	log.Println("Starting scheduled full backup...")
-	err := PerformFullBackup("", "Scheduled full backup")
+func fullBackupJob(target *Target) {
+	log.Printf("Starting scheduled full backup of target %s...\n", target.Name)
+	job, err := SubmitFullBackup(target, "", "Scheduled full backup", nil)
+	if err == ErrJobConflict {
+		log.Printf("Skipped scheduled full backup of target %s, job #%d is already queued or running.", target.Name, job.ID)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to queue scheduled full backup of target %s: %v", target.Name, err)
+		return
+	}
+	err = job.Wait()
 	if err != nil {
-		log.Printf("Scheduled full backup failed: %v", err)
+		log.Printf("Scheduled full backup of target %s failed: %v", target.Name, err)
 	} else {
-		log.Println("Scheduled full backup completed successfully.")
+		log.Printf("Scheduled full backup of target %s completed successfully.\n", target.Name)
 	}
 }
-func incrementalBackupJob() {
-	log.Println("Starting scheduled incremental backup...")
-	err := PerformIncrementalBackup("", "Scheduled incremental backup")
+func incrementalBackupJob(target *Target) {
+	log.Printf("Starting scheduled incremental backup of target %s...\n", target.Name)
+	job, err := SubmitIncrementalBackup(target, "", "Scheduled incremental backup", nil)
+	if err == ErrJobConflict {
+		log.Printf("Skipped scheduled incremental backup of target %s, job #%d is already queued or running.", target.Name, job.ID)
+		return
+	}
 	if err != nil {
-		log.Printf("Scheduled incremental backup failed: %v", err)
+		log.Printf("Failed to queue scheduled incremental backup of target %s: %v", target.Name, err)
+		return
+	}
+	err = job.Wait()
+	if err != nil {
+		log.Printf("Scheduled incremental backup of target %s failed: %v", target.Name, err)
 	} else {
-		log.Println("Scheduled incremental backup completed successfully.")
+		log.Printf("Scheduled incremental backup of target %s completed successfully.\n", target.Name)
 	}
 }
 func cleanupJob() {
 	log.Println("Starting scheduled cleanup of old backups...")
-	backups, err := tracker.GetOldBackups()
+	job, err := SubmitCleanup()
+	if err == ErrJobConflict {
+		log.Printf("Skipped scheduled cleanup, job #%d is already queued or running.", job.ID)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to queue scheduled cleanup: %v", err)
+		return
+	}
+	err = job.Wait()
 	if err != nil {
 		log.Printf("Scheduled cleanup failed: %v", err)
+	}
+}
+func scrubJob() {
+	log.Println("Starting scheduled scrub of backup copies...")
+	job, err := SubmitScrub()
+	if err == ErrJobConflict {
+		log.Printf("Skipped scheduled scrub, job #%d is already queued or running.", job.ID)
 		return
 	}
-	for _, backup := range backups {
-		err := DeleteLocalBackup(backup)
-		if err != nil {
-			log.Printf("Failed to delete local backup %s: %v", backup.GetBackupPath(), err)
-		}
+	if err != nil {
+		log.Printf("Failed to queue scheduled scrub: %v", err)
+		return
+	}
+	err = job.Wait()
+	if err != nil {
+		log.Printf("Scheduled scrub failed: %v", err)
+	} else {
+		log.Println("Scheduled scrub completed successfully.")
 	}
 }
 func rcloneUploadJob() {
 	log.Println("Starting scheduled rclone upload of pending backups...")
-	backups, err := tracker.GetPendingUploads()
+	// Backups saved by older versions have no queued upload yet.
+	backups, err := tracker.GetBackupsWithoutUpload()
 	if err != nil {
 		log.Printf("Scheduled rclone upload failed: %v", err)
 		return
 	}
 	for _, backup := range backups {
-		err := UploadToRClone(backup.BackupTime, config.DefaultRCloneRemote, backup.IsIncrementalBackup())
+		remote := config.DefaultRCloneRemote
+		if target := GetTarget(backup.Target); target != nil {
+			remote = target.DefaultRCloneRemote
+		}
+		err := tracker.EnqueueUpload(backup.ID, remote)
 		if err != nil {
-			log.Printf("Failed to upload backup %s: %v", backup.GetBackupPath(), err)
-			continue
+			log.Printf("Failed to queue upload of backup %s: %v", backup.GetBackupPath(), err)
+		}
+	}
+	DispatchUploads()
+}
+
+// Names of the scheduled jobs. Backup jobs run per target and their schedules are named
+// "<target>/<job>", e.g. "main/full".
+const (
+	ScheduleFull        = "full"
+	ScheduleIncremental = "incremental"
+	ScheduleCleanup     = "cleanup"
+	ScheduleUpload      = "upload"
+	ScheduleScrub       = "scrub"
+)
+
+// The paused state of a scheduled job, persisted in the tracker.
+type PauseState struct {
+	// Why the job was paused.
+	Reason string `json:"reason,omitempty"`
+	// When the job was paused.
+	PausedAt time.Time `json:"paused_at"`
+	// When the job resumes automatically, zero if it must be resumed manually.
+	ResumeAt time.Time `json:"resume_at,omitzero"`
+}
+
+type Schedule struct {
+	// Unique name, as used by the scheduler API and the tracker.
+	Name string
+	// The job run by the schedule, e.g. "full".
+	Job string
+	// The target the job runs for, empty for jobs covering all targets.
+	Target   string
+	Interval time.Duration
+	// Whether the job respects blackout windows.
+	Blackout bool
+
+	run         func()
+	ticker      *time.Ticker
+	pause       *PauseState
+	resumeTimer *time.Timer
+}
+
+var (
+	schedulesMu sync.Mutex
+	schedules   []*Schedule
+)
+
+// Run the job unless it is paused or falls inside a blackout window.
+func (s *Schedule) trigger() {
+	schedulesMu.Lock()
+	paused := s.pause != nil
+	schedulesMu.Unlock()
+	if paused {
+		return
+	}
+	if s.Blackout && !allowedOutsideBlackout(s.Name, s.trigger) {
+		return
+	}
+	s.run()
+}
+
+func GetSchedule(name string) *Schedule {
+	for _, s := range schedules {
+		if s.Name == name {
+			return s
 		}
-		tracker.UpdateBackupStatus(backup.BackupTime, Uploaded)
+	}
+	return nil
+}
+
+// Whether a scheduled job is paused.
+func IsSchedulePaused(name string) bool {
+	schedulesMu.Lock()
+	defer schedulesMu.Unlock()
+	s := GetSchedule(name)
+	return s != nil && s.pause != nil
+}
+
+// Restart the interval of a scheduled job, e.g. after the same job was run manually.
+// Paused jobs are left untouched.
+func ResetSchedule(name string) {
+	schedulesMu.Lock()
+	defer schedulesMu.Unlock()
+	s := GetSchedule(name)
+	if s != nil && s.pause == nil {
+		s.ticker.Reset(s.Interval)
 	}
 }
 
-// Initialize and start scheduled jobs.
+// Pause a scheduled job. If resumeAt is not zero, the job resumes automatically at that time.
+func PauseSchedule(name string, reason string, resumeAt time.Time) error {
+	schedulesMu.Lock()
+	defer schedulesMu.Unlock()
+	s := GetSchedule(name)
+	if s == nil {
+		return fmt.Errorf("unknown scheduled job %q", name)
+	}
+	state := &PauseState{Reason: reason, PausedAt: time.Now(), ResumeAt: resumeAt}
+	err := tracker.SavePauseState(name, *state)
+	if err != nil {
+		return err
+	}
+	s.pauseLocked(state)
+	log.Printf("Paused scheduled %s job, reason: %q\n", name, reason)
+	return nil
+}
+
+// Resume a paused scheduled job.
+func ResumeSchedule(name string) error {
+	schedulesMu.Lock()
+	defer schedulesMu.Unlock()
+	s := GetSchedule(name)
+	if s == nil {
+		return fmt.Errorf("unknown scheduled job %q", name)
+	}
+	if s.pause == nil {
+		return nil
+	}
+	err := tracker.DeletePauseState(name)
+	if err != nil {
+		return err
+	}
+	if s.resumeTimer != nil {
+		s.resumeTimer.Stop()
+		s.resumeTimer = nil
+	}
+	s.pause = nil
+	s.ticker.Reset(s.Interval)
+	log.Printf("Resumed scheduled %s job\n", name)
+	return nil
+}
+
+// Stop the ticker and arm the auto-resume timer. schedulesMu must be held.
+func (s *Schedule) pauseLocked(state *PauseState) {
+	s.pause = state
+	s.ticker.Stop()
+	if s.resumeTimer != nil {
+		s.resumeTimer.Stop()
+		s.resumeTimer = nil
+	}
+	if !state.ResumeAt.IsZero() {
+		name := s.Name
+		s.resumeTimer = time.AfterFunc(time.Until(state.ResumeAt), func() {
+			err := ResumeSchedule(name)
+			if err != nil {
+				log.Printf("Failed to auto-resume scheduled %s job: %v", name, err)
+			}
+		})
+	}
+}
+
+// A snapshot of a scheduled job for status output.
+type ScheduleStatus struct {
+	Name     string      `json:"name"`
+	Job      string      `json:"job"`
+	Target   string      `json:"target,omitempty"`
+	Interval string      `json:"interval"`
+	Paused   bool        `json:"paused"`
+	Pause    *PauseState `json:"pause,omitempty"`
+}
+
+func GetScheduleStatuses() []ScheduleStatus {
+	schedulesMu.Lock()
+	defer schedulesMu.Unlock()
+	statuses := make([]ScheduleStatus, 0, len(schedules))
+	for _, s := range schedules {
+		status := ScheduleStatus{Name: s.Name, Job: s.Job, Target: s.Target, Interval: s.Interval.String(), Paused: s.pause != nil}
+		if s.pause != nil {
+			pause := *s.pause
+			status.Pause = &pause
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// Initialize and start scheduled jobs, restoring paused states from the tracker.
 func InitializeJobs() {
-	fullBackupTicker = time.NewTicker(config.FullBackupInterval)
-	incrementalBackupTicker = time.NewTicker(config.IncrementalBackupInterval)
-	cleanupTicker = time.NewTicker(config.CleanupInterval)
-	rcloneUploadTicker = time.NewTicker(config.RcloneUploadInterval)
-
-	go func() {
-		for range fullBackupTicker.C {
-			fullBackupJob()
+	schedules = nil
+	for _, target := range config.Targets {
+		schedules = append(schedules,
+			&Schedule{Name: target.ScheduleName(ScheduleFull), Job: ScheduleFull, Target: target.Name, Interval: target.FullBackupInterval, Blackout: true, run: func() { fullBackupJob(target) }},
+			&Schedule{Name: target.ScheduleName(ScheduleIncremental), Job: ScheduleIncremental, Target: target.Name, Interval: target.IncrementalBackupInterval, Blackout: true, run: func() { incrementalBackupJob(target) }},
+		)
+	}
+	schedules = append(schedules,
+		&Schedule{Name: ScheduleCleanup, Job: ScheduleCleanup, Interval: config.CleanupInterval, run: cleanupJob},
+		&Schedule{Name: ScheduleUpload, Job: ScheduleUpload, Interval: config.RcloneUploadInterval, Blackout: true, run: rcloneUploadJob},
+		&Schedule{Name: ScheduleScrub, Job: ScheduleScrub, Interval: config.ScrubInterval, Blackout: true, run: scrubJob},
+	)
+
+	pauses, err := tracker.GetPauseStates()
+	if err != nil {
+		log.Fatalf("Failed to load paused scheduled jobs: %v", err)
+	}
+	migrateLegacyPauses(pauses)
+
+	schedulesMu.Lock()
+	defer schedulesMu.Unlock()
+	for _, s := range schedules {
+		s.ticker = time.NewTicker(s.Interval)
+		if state, ok := pauses[s.Name]; ok {
+			log.Printf("Scheduled %s job is paused since %s, reason: %q\n", s.Name, state.PausedAt.Format(time.DateTime), state.Reason)
+			s.pauseLocked(&state)
 		}
-	}()
-	go func() {
-		for range incrementalBackupTicker.C {
-			incrementalBackupJob()
+		go func() {
+			for range s.ticker.C {
+				s.trigger()
+			}
+		}()
+	}
+}
+
+// Move pauses saved by versions without targets, keyed by the bare job name, to the backup
+// schedules of every target.
+func migrateLegacyPauses(pauses map[string]PauseState) {
+	for _, job := range []string{ScheduleFull, ScheduleIncremental} {
+		state, ok := pauses[job]
+		if !ok {
+			continue
 		}
-	}()
-	go func() {
-		for range cleanupTicker.C {
-			cleanupJob()
+		for _, target := range config.Targets {
+			name := target.ScheduleName(job)
+			if _, ok := pauses[name]; ok {
+				continue
+			}
+			err := tracker.SavePauseState(name, state)
+			if err != nil {
+				log.Printf("Failed to migrate pause of scheduled %s job: %v", job, err)
+				return
+			}
+			pauses[name] = state
 		}
-	}()
-	go func() {
-		for range rcloneUploadTicker.C {
-			rcloneUploadJob()
+		err := tracker.DeletePauseState(job)
+		if err != nil {
+			log.Printf("Failed to migrate pause of scheduled %s job: %v", job, err)
 		}
-	}()
+		delete(pauses, job)
+	}
 }
 
 // Stop all scheduled jobs.
 func StopJobs() {
-	fullBackupTicker.Stop()
-	incrementalBackupTicker.Stop()
-	cleanupTicker.Stop()
-	rcloneUploadTicker.Stop()
+	schedulesMu.Lock()
+	defer schedulesMu.Unlock()
+	for _, s := range schedules {
+		s.ticker.Stop()
+		if s.resumeTimer != nil {
+			s.resumeTimer.Stop()
+		}
+	}
 }
@@ -0,0 +1,200 @@
+// Periodically re-verify local and remote copies of backups against their manifests to detect bit rot.
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"math"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Outcomes of scrubbing a copy of a backup.
+const (
+	// The copy matches its manifest.
+	ScrubOK = "ok"
+	// Files of the copy are missing or do not match their checksums.
+	ScrubCorrupted = "corrupted"
+	// The copy could not be verified, e.g. because the remote was unreachable.
+	ScrubFailed = "failed"
+)
+
+// The location of the local copy of a backup. Remote copies are located by their remote, e.g. "onedrive:".
+const scrubLocal = "local"
+
+// The last scrub outcome of a copy of a backup.
+type ScrubResult struct {
+	BackupID   int64  `json:"backup_id"`
+	Target     string `json:"target"`
+	BackupName string `json:"backup_name"`
+	// "local", or the remote of the copy, e.g. "onedrive:".
+	Location   string    `json:"location"`
+	VerifiedAt time.Time `json:"verified_at"`
+	Outcome    string    `json:"outcome"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// A copy of a backup to scrub.
+type scrubCopy struct {
+	track    DatabaseTrack
+	location string
+	// The backup directory, or the object of a streamed backup, on the remote.
+	remotePath string
+	// When the copy was last scrubbed, zero if it never was.
+	lastVerified time.Time
+}
+
+// Verify all local copies and the least recently verified fraction of the remote copies of backups
+// with a manifest, record the outcomes and alert on corrupted copies.
+func ScrubBackups(ctx context.Context) error {
+	copies, err := scrubCopies()
+	if err != nil {
+		return err
+	}
+	var local, remote []scrubCopy
+	for _, c := range copies {
+		if c.location == scrubLocal {
+			local = append(local, c)
+		} else {
+			remote = append(remote, c)
+		}
+	}
+	slices.SortStableFunc(remote, func(a, b scrubCopy) int {
+		return a.lastVerified.Compare(b.lastVerified)
+	})
+	sampled := int(math.Ceil(float64(len(remote)) * config.ScrubRemoteFraction))
+	remote = remote[:sampled]
+	JobLogf(ctx, "Scrubbing %d local and %d of %d remote copies", len(local), sampled, len(copies)-len(local))
+
+	var corrupted []string
+	failed := 0
+	for _, c := range append(local, remote...) {
+		err := verifyScrubCopy(ctx, c)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil && c.location == scrubLocal && archivedDuringScrub(c.track.ID) {
+			continue
+		}
+		result := ScrubResult{BackupID: c.track.ID, Location: c.location, VerifiedAt: time.Now(), Outcome: ScrubOK}
+		if errors.Is(err, errManifestMismatch) {
+			result.Outcome = ScrubCorrupted
+			corrupted = append(corrupted, err.Error())
+		} else if err != nil {
+			result.Outcome = ScrubFailed
+			failed++
+		}
+		if err != nil {
+			result.Error = err.Error()
+			log.Printf("Scrub of backup %s at %s: %v\n", c.track.BackupName(), c.location, err)
+			JobLogf(ctx, "Scrub of backup %s at %s: %v", c.track.BackupName(), c.location, err)
+		}
+		trackErr := tracker.RecordScrub(result)
+		if trackErr != nil {
+			log.Printf("Failed to record scrub of backup %s at %s: %v\n", c.track.BackupName(), c.location, trackErr)
+		}
+	}
+
+	if len(corrupted) > 0 {
+		SendAlert(
+			fmt.Sprintf("Scrub found %d corrupted backup copies", len(corrupted)),
+			strings.Join(corrupted, "\n"),
+		)
+	}
+	if len(corrupted) > 0 || failed > 0 {
+		return fmt.Errorf("Scrub found %d corrupted copies and failed to verify %d copies", len(corrupted), failed)
+	}
+	log.Printf("Scrub of %d local and %d remote copies completed successfully.\n", len(local), sampled)
+	return nil
+}
+
+// The copies of tracked backups with a manifest: local backups not archived yet, uploaded backups on
+// every remote they were uploaded to, and streamed backups on their remote unless remote retention
+// deleted them.
+func scrubCopies() ([]scrubCopy, error) {
+	backups, err := tracker.GetAllBackups()
+	if err != nil {
+		return nil, err
+	}
+	uploads, err := tracker.GetDoneUploads()
+	if err != nil {
+		return nil, err
+	}
+	// The path of the latest upload of each backup to each remote.
+	uploaded := make(map[int64]map[string]string)
+	for _, upload := range uploads {
+		if uploaded[upload.BackupID] == nil {
+			uploaded[upload.BackupID] = make(map[string]string)
+		}
+		uploaded[upload.BackupID][cmp.Or(upload.Remote, config.DefaultRCloneRemote)] = upload.RemoteBackupPath()
+	}
+	results, err := tracker.ListScrubs("")
+	if err != nil {
+		return nil, err
+	}
+	type copyKey struct {
+		backupID int64
+		location string
+	}
+	deletedUploads, err := tracker.GetDeletedUploads()
+	if err != nil {
+		return nil, err
+	}
+	deleted := make(map[copyKey]bool)
+	for _, upload := range deletedUploads {
+		deleted[copyKey{upload.BackupID, cmp.Or(upload.Remote, config.DefaultRCloneRemote)}] = true
+	}
+	lastVerified := make(map[copyKey]time.Time)
+	for _, result := range results {
+		lastVerified[copyKey{result.BackupID, result.Location}] = result.VerifiedAt
+	}
+
+	var copies []scrubCopy
+	add := func(track DatabaseTrack, location string, remotePath string) {
+		copies = append(copies, scrubCopy{track: track, location: location, remotePath: remotePath, lastVerified: lastVerified[copyKey{track.ID, location}]})
+	}
+	for _, track := range backups {
+		if track.Manifest == nil {
+			continue
+		}
+		if track.IsStreamed() {
+			remote, object := splitRemoteObject(track.RemoteObject)
+			if !deleted[copyKey{track.ID, remote}] {
+				add(track, remote, object)
+			}
+			continue
+		}
+		if track.Status != Archived {
+			add(track, scrubLocal, "")
+		}
+		for _, remote := range slices.Sorted(maps.Keys(uploaded[track.ID])) {
+			add(track, remote, uploaded[track.ID][remote])
+		}
+	}
+	return copies, nil
+}
+
+// Whether the local copy of a backup was deleted by a cleanup while it was scrubbed.
+func archivedDuringScrub(backupID int64) bool {
+	track, err := tracker.GetBackup(backupID)
+	return err == nil && track.Status == Archived
+}
+
+// Verify a copy of a backup against its manifest, downloading remote copies without listed hashes.
+func verifyScrubCopy(ctx context.Context, c scrubCopy) error {
+	if c.location == scrubLocal {
+		return verifyLocalCopy(ctx, c.track.GetBackupPath(), c.track.Manifest)
+	}
+	ctx, cancel := WithOptionalTimeout(ctx, config.DownloadTimeout)
+	defer cancel()
+	if c.track.IsStreamed() {
+		return verifyRemoteCopy(ctx, c.location, path.Dir(c.remotePath), c.track.Manifest, true, VerifyChecksum)
+	}
+	return verifyRemoteCopy(ctx, c.location, c.remotePath, c.track.Manifest, false, VerifyChecksum)
+}
@@ -0,0 +1,508 @@
+// Store backups on remotes through rclone, the S3 API or a local directory.
+package main
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The storage of a remote. Paths are absolute slash-separated paths on the remote, e.g.
+// "/backup/db_20251130_1200"; where backups are stored is decided by the RemoteLayout of their target.
+type Storage interface {
+	// Upload the files below localDir to the directory path, reporting the progress to the job of ctx.
+	PutDir(ctx context.Context, localDir string, path string, throttle Throttle) error
+	// Download the objects below the directory path into localDir, reporting the progress to the job of ctx.
+	GetDir(ctx context.Context, path string, localDir string, throttle Throttle) error
+	// Write the object at path from r, which is read until EOF. A failed write leaves no object behind.
+	Put(ctx context.Context, path string, r io.Reader, throttle Throttle) error
+	// Read the object at path.
+	Get(ctx context.Context, path string, throttle Throttle) (io.ReadCloser, error)
+	// List the objects below the directory path recursively, sorted by path. Empty if there are none.
+	List(ctx context.Context, path string) ([]StorageObject, error)
+	// The object at path, an error wrapping fs.ErrNotExist if there is none.
+	Stat(ctx context.Context, path string) (StorageObject, error)
+	// Delete the object at path, or all objects below path if it is a directory.
+	Delete(ctx context.Context, path string) error
+}
+
+// An object on a remote.
+type StorageObject struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// The kinds of storage a remote can use.
+const (
+	StorageRClone = "rclone"
+	StorageS3     = "s3"
+	StorageLocal  = "local"
+)
+
+// A remote configured in config.json. Remotes that are not configured are rclone remotes of rclone.conf.
+// Remotes are referred to by their name followed by a colon, e.g. "minio:", like rclone remotes.
+type RemoteConfig struct {
+	Name string `json:"name"`
+	// rclone, s3 or local.
+	Type string `json:"type"`
+
+	// The directory backups are stored in, for local remotes, e.g. an NFS mount.
+	Path string `json:"path"`
+
+	// The S3 endpoint, https://s3.<region>.amazonaws.com if empty.
+	Endpoint string `json:"endpoint"`
+	// The S3 region, us-east-1 if empty.
+	Region string `json:"region"`
+	Bucket string `json:"bucket"`
+	// A key prefix to store the backups under, e.g. "mysql/".
+	Prefix string `json:"prefix"`
+	// Read from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY if empty.
+	AccessKeyID     string `json:"access_key_id"`
+	SecretAccessKey string `json:"secret_access_key"`
+	// Address buckets as endpoint/bucket instead of bucket.endpoint, as required by MinIO.
+	PathStyle bool `json:"path_style"`
+}
+
+var remoteNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// The storages of the configured remotes by name.
+var storages = make(map[string]Storage)
+
+// Check the configured remotes and create their storages.
+func initializeRemotes() error {
+	for _, remote := range config.Remotes {
+		if !remoteNameRegexp.MatchString(remote.Name) {
+			return fmt.Errorf("invalid remote name %q", remote.Name)
+		}
+		if storages[remote.Name] != nil {
+			return fmt.Errorf("duplicate remote %q", remote.Name)
+		}
+		var storage Storage
+		var err error
+		switch remote.Type {
+		case StorageRClone, "":
+			storage = &rcloneStorage{remote: remote.Name + ":"}
+		case StorageS3:
+			storage, err = newS3Storage(remote)
+		case StorageLocal:
+			storage, err = newLocalStorage(remote)
+		default:
+			err = fmt.Errorf("invalid type %q", remote.Type)
+		}
+		if err != nil {
+			return fmt.Errorf("remote %s: %w", remote.Name, err)
+		}
+		storages[remote.Name] = storage
+	}
+	return nil
+}
+
+// The storage of a remote such as "onedrive:". Remotes that are not configured use rclone.
+func GetStorage(remote string) Storage {
+	if storage, ok := storages[strings.TrimSuffix(remote, ":")]; ok {
+		return storage
+	}
+	return &rcloneStorage{remote: remote}
+}
+
+// Split a remote object such as "onedrive:/backup/db_20251130_1200.xbstream" into its remote and path.
+func splitRemoteObject(object string) (string, string) {
+	if i := strings.Index(object, ":"); i >= 0 && !strings.Contains(object[:i], "/") {
+		return object[:i+1], object[i+1:]
+	}
+	return "", object
+}
+
+// A backup found on a remote.
+type RemoteBackup struct {
+	// The name to download the backup by, e.g. db_20251130_1200 or db_20251130_1200.xbstream.
+	Name       string    `json:"name"`
+	Type       string    `json:"type"`
+	BackupTime time.Time `json:"backup_time"`
+	// The total size of the files of the backup in bytes.
+	Size     int64 `json:"size"`
+	Files    int   `json:"files"`
+	Streamed bool  `json:"streamed"`
+	// Whether the backup is known to the tracker, and its ID if so.
+	Tracked  bool  `json:"tracked"`
+	BackupID int64 `json:"backup_id,omitempty"`
+}
+
+// List the backups of a target on a remote where its layout puts them, oldest first. Other objects
+// below the root of the layout are ignored.
+func ListRemoteBackups(ctx context.Context, target *Target, remote string) ([]RemoteBackup, error) {
+	if remote == "" {
+		remote = target.DefaultRCloneRemote
+	}
+	layout := targetRemoteLayout(target.Name)
+	objects, err := GetStorage(remote).List(ctx, layout.Root())
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list backups on remote %s: %w", remote, err)
+	}
+	tracks, err := tracker.GetTargetBackups(target.Name)
+	if err != nil {
+		return nil, err
+	}
+	tracked := make(map[string]int64)
+	for _, track := range tracks {
+		tracked[track.BackupName()] = track.ID
+	}
+
+	backups := make(map[string]*RemoteBackup)
+	for _, object := range objects {
+		name, ok := layout.BackupName(object.Path)
+		if !ok {
+			continue
+		}
+		backup, ok := backups[name]
+		if !ok {
+			backupTime, backupType, _ := ParseBackupName(name)
+			backup = &RemoteBackup{Name: name, Type: backupType, BackupTime: backupTime, Streamed: strings.HasSuffix(name, xbstreamSuffix)}
+			backup.BackupID, backup.Tracked = tracked[name]
+			backups[name] = backup
+		}
+		backup.Size += object.Size
+		backup.Files++
+	}
+	result := make([]RemoteBackup, 0, len(backups))
+	for _, backup := range backups {
+		result = append(result, *backup)
+	}
+	slices.SortFunc(result, func(a, b RemoteBackup) int {
+		return cmp.Or(a.BackupTime.Compare(b.BackupTime), strings.Compare(a.Name, b.Name))
+	})
+	return result, nil
+}
+
+// Upload a local backup directory to the directory remotePath on the remote.
+func UploadToRemote(ctx context.Context, localDir string, remotePath string, remote string) error {
+	ctx, cancel := WithOptionalTimeout(ctx, config.UploadTimeout)
+	defer cancel()
+	if remote == "" {
+		remote = config.DefaultRCloneRemote
+	}
+
+	log.Printf("Uploading backup %s to %s%s\n", localDir, remote, remotePath)
+	err := GetStorage(remote).PutDir(ctx, localDir, remotePath, currentThrottle(ctx))
+	if err != nil {
+		// A partial upload is left on the remote, the next upload of this backup copies the files again.
+		return fmt.Errorf("Failed to upload backup to remote %s: %w", remote, err)
+	}
+	log.Printf("Backup %s uploaded to %s%s successfully.\n", localDir, remote, remotePath)
+	return nil
+}
+
+// The local directory name of a downloaded backup. Streamed backups are extracted
+// into a directory named after the object without its .xbstream suffix.
+func DownloadDirName(backupName string) string {
+	return strings.TrimSuffix(backupName, xbstreamSuffix)
+}
+
+// Download a backup directory of a target, or extract a streamed backup object with xbstream.
+func DownloadFromRemote(ctx context.Context, target *Target, remote string, backupName string) error {
+	ctx, cancel := WithOptionalTimeout(ctx, config.DownloadTimeout)
+	defer cancel()
+	if remote == "" {
+		remote = target.DefaultRCloneRemote
+	}
+
+	log.Printf("Downloading backup %s of %s from remote %s\n", backupName, target.Name, remote)
+	storage := GetStorage(remote)
+	localDir := target.DownloadDir + DownloadDirName(backupName)
+	remotePath, err := remoteBackupPath(target, remote, backupName)
+	if err != nil {
+		return err
+	}
+	throttle := currentThrottle(ctx)
+	if strings.HasSuffix(backupName, xbstreamSuffix) {
+		err = extractRemoteStream(ctx, storage, remotePath, localDir, throttle)
+	} else {
+		err = storage.GetDir(ctx, remotePath, localDir, throttle)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			RemovePartialOutput(localDir)
+		}
+		return fmt.Errorf("Failed to download backup from remote %s: %w", remote, err)
+	}
+	log.Printf("Backup %s downloaded from remote %s successfully.\n", backupName, remote)
+	return nil
+}
+
+// The path of a backup of a target on a remote: where the tracker recorded it was uploaded or streamed
+// to, or where the layout of the target puts it for backups unknown to the tracker.
+func remoteBackupPath(target *Target, remote string, backupName string) (string, error) {
+	track, err := tracker.GetBackupByName(target.Name, DownloadDirName(backupName))
+	if err == nil {
+		if track.IsStreamed() && track.BackupName() == backupName {
+			trackRemote, object := splitRemoteObject(track.RemoteObject)
+			if trackRemote == remote {
+				return object, nil
+			}
+		}
+		uploads, err := tracker.GetDoneUploadsOfBackup(track.ID)
+		if err != nil {
+			return "", err
+		}
+		for _, upload := range slices.Backward(uploads) {
+			if cmp.Or(upload.Remote, config.DefaultRCloneRemote) == remote {
+				return upload.RemoteBackupPath(), nil
+			}
+		}
+	} else if err != sql.ErrNoRows {
+		return "", err
+	}
+	return targetRemoteLayout(target.Name).Path(backupName)
+}
+
+// Extract a streamed backup object into localDir with xbstream.
+func extractRemoteStream(ctx context.Context, storage Storage, path string, localDir string, throttle Throttle) error {
+	err := os.MkdirAll(localDir, 0o755)
+	if err != nil {
+		return err
+	}
+	var size int64
+	if info, err := storage.Stat(ctx, path); err == nil {
+		size = info.Size
+	}
+	meter := newTransferMeter(ctx, "download", size)
+	object, err := storage.Get(ctx, path, throttle)
+	if err != nil {
+		return err
+	}
+	defer object.Close()
+	output, err := RunSubprocessWithInput(ctx, meter.Reader(object), "xbstream", "-x", "-C", localDir)
+	if err != nil {
+		return fmt.Errorf("%w, output: %s", err, output)
+	}
+	return nil
+}
+
+// Delete a partially uploaded object, e.g. after a failed stream. Errors are only logged.
+func DeleteRemoteObject(ctx context.Context, object string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), remoteCleanupTimeout)
+	defer cancel()
+	remote, path := splitRemoteObject(object)
+	err := GetStorage(remote).Delete(ctx, path)
+	if err != nil {
+		log.Printf("Failed to delete partial remote object %s: %v", object, err)
+		return
+	}
+	log.Printf("Deleted partial remote object %s\n", object)
+}
+
+// The files below localDir, as paths relative to it, and their total size.
+func localFiles(localDir string) ([]string, int64, error) {
+	var files []string
+	var size int64
+	err := filepath.WalkDir(localDir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(localDir, file)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		size += info.Size()
+		return nil
+	})
+	return files, size, err
+}
+
+// Upload the files below localDir one by one with Put, for storages without directory transfers.
+func putDirFiles(ctx context.Context, storage Storage, localDir string, dir string, throttle Throttle) error {
+	files, size, err := localFiles(localDir)
+	if err != nil {
+		return err
+	}
+	meter := newTransferMeter(ctx, "upload", size)
+	for _, file := range files {
+		f, err := os.Open(filepath.Join(localDir, filepath.FromSlash(file)))
+		if err != nil {
+			return err
+		}
+		err = storage.Put(ctx, path.Join(dir, file), meter.Reader(f), throttle)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		meter.FileDone()
+	}
+	return nil
+}
+
+// Download the objects below dir one by one with Get, for storages without directory transfers.
+func getDirFiles(ctx context.Context, storage Storage, dir string, localDir string, throttle Throttle) error {
+	objects, err := storage.List(ctx, dir)
+	if err != nil {
+		return err
+	}
+	if len(objects) == 0 {
+		return fmt.Errorf("%s: %w", dir, fs.ErrNotExist)
+	}
+	var size int64
+	for _, object := range objects {
+		size += object.Size
+	}
+	meter := newTransferMeter(ctx, "download", size)
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	for _, object := range objects {
+		file := filepath.Join(localDir, filepath.FromSlash(strings.TrimPrefix(object.Path, prefix)))
+		err = getFile(ctx, storage, object.Path, file, meter, throttle)
+		if err != nil {
+			return fmt.Errorf("%s: %w", object.Path, err)
+		}
+		meter.FileDone()
+	}
+	return nil
+}
+
+func getFile(ctx context.Context, storage Storage, path string, file string, meter *transferMeter, throttle Throttle) error {
+	err := os.MkdirAll(filepath.Dir(file), 0o755)
+	if err != nil {
+		return err
+	}
+	object, err := storage.Get(ctx, path, throttle)
+	if err != nil {
+		return err
+	}
+	defer object.Close()
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, meter.Reader(object))
+	closeErr := f.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// Counts the bytes of a transfer and reports its progress to the job of ctx.
+type transferMeter struct {
+	mu         sync.Mutex
+	ctx        context.Context
+	started    time.Time
+	progress   Progress
+	lastReport time.Time
+}
+
+func newTransferMeter(ctx context.Context, phase string, total int64) *transferMeter {
+	m := &transferMeter{
+		ctx:      ctx,
+		started:  time.Now(),
+		progress: Progress{Phase: phase, BytesTotal: total},
+	}
+	ReportProgress(ctx, m.progress)
+	return m
+}
+
+// Wrap r to count the bytes read from it.
+func (m *transferMeter) Reader(r io.Reader) io.Reader {
+	return &meteredReader{r: r, meter: m}
+}
+
+func (m *transferMeter) FileDone() {
+	m.mu.Lock()
+	m.progress.FilesDone++
+	m.mu.Unlock()
+	m.add(0)
+}
+
+func (m *transferMeter) add(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.progress.BytesDone += int64(n)
+	if time.Since(m.lastReport) < progressReportInterval && m.progress.BytesDone < m.progress.BytesTotal {
+		return
+	}
+	elapsed := time.Since(m.started).Seconds()
+	if elapsed > 0 {
+		m.progress.BytesPerSecond = float64(m.progress.BytesDone) / elapsed
+	}
+	if m.progress.BytesTotal > 0 {
+		m.progress.Percent = min(100, float64(m.progress.BytesDone)*100/float64(m.progress.BytesTotal))
+		if m.progress.BytesPerSecond > 0 {
+			m.progress.ETASeconds = int64(float64(m.progress.BytesTotal-m.progress.BytesDone) / m.progress.BytesPerSecond)
+		}
+	}
+	m.lastReport = time.Now()
+	ReportProgress(m.ctx, m.progress)
+}
+
+type meteredReader struct {
+	r     io.Reader
+	meter *transferMeter
+}
+
+func (r *meteredReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.meter.add(n)
+	return n, err
+}
+
+// Limits reads from r to rate bytes per second on average, for storages that do not limit bandwidth themselves.
+type rateLimitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	rate    int64
+	started time.Time
+	read    int64
+}
+
+func newRateLimitedReader(ctx context.Context, r io.Reader, rate int64) io.Reader {
+	if rate <= 0 {
+		return r
+	}
+	return &rateLimitedReader{ctx: ctx, r: r, rate: rate, started: time.Now()}
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	// Read at most a tenth of a second's worth at once, so that the rate stays even.
+	p = p[:min(int64(len(p)), max(r.rate/10, 1))]
+	n, err := r.r.Read(p)
+	r.read += int64(n)
+	wait := time.Duration(float64(r.read)/float64(r.rate)*float64(time.Second)) - time.Since(r.started)
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-r.ctx.Done():
+			return n, r.ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return n, err
+}
+
+// Combine a reader with the closer of the object it reads from.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// Sort objects by path, as listings of different storages come in different orders.
+func sortObjects(objects []StorageObject) {
+	slices.SortFunc(objects, func(a, b StorageObject) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Write the files of a backup directory and return their SHA-256 hashes by path.
+func writeBackupTestFiles(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := map[string]string{
+		"xtrabackup_checkpoints": "backup_type = full-backuped\n",
+		"ibdata1.zst":            "ibdata",
+		"db/users.ibd.zst":       "users",
+	}
+	sums := make(map[string]string)
+	for name, content := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		err := os.MkdirAll(filepath.Dir(file), 0o755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(file, []byte(content), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum := sha256.Sum256([]byte(content))
+		sums[name] = hex.EncodeToString(sum[:])
+	}
+	return sums
+}
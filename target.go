@@ -0,0 +1,273 @@
+// MySQL instances backed up by the service, each with its own credentials, schedules and catalog.
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// A MySQL instance to back up. Settings left empty fall back to the top-level settings of config.json.
+type Target struct {
+	// Unique name, used in directory names, schedule names and HTTP paths.
+	Name string `json:"name"`
+
+	MysqlUser         string `json:"mysql_user"`
+	MysqlPassword     string `json:"mysql_password"`
+	MysqlPasswordFile string `json:"mysql_password_file"`
+	MysqlHost         string `json:"mysql_host"`
+	MysqlPort         int    `json:"mysql_port"`
+	// Connect through this unix socket instead of host and port.
+	MysqlSocket string `json:"mysql_socket"`
+	// An option file read by xtrabackup instead of the default option files, e.g. the my.cnf of the instance.
+	MysqlDefaultsFile string `json:"mysql_defaults_file"`
+	MysqlSSLMode      string `json:"mysql_ssl_mode"`
+	MysqlSSLCA        string `json:"mysql_ssl_ca"`
+	MysqlSSLCert      string `json:"mysql_ssl_cert"`
+	MysqlSSLKey       string `json:"mysql_ssl_key"`
+	// The data directory of the instance, as mounted into the container.
+	Datadir string `json:"datadir"`
+
+	// Where backups are stored locally, <backup_dir>/<name>/ by default.
+	BackupDir string `json:"backup_dir"`
+	// Where downloaded backups are stored, <download_dir>/<name>/ by default.
+	DownloadDir string `json:"download_dir"`
+	// The directory backups are stored in on remotes, a template such as "/mysql/{target}/{year}/{month}".
+	// The top-level remote_path by default; if both are empty, the path of backup_dir.
+	RemotePath string `json:"remote_path"`
+
+	LocalBackupCount             int                `json:"local_backup_count"`
+	DefaultRCloneRemote          string             `json:"default_rclone_remote"`
+	RemoteBackupCount            int                `json:"remote_backup_count"`
+	FullBackupIntervalStr        string             `json:"full_backup_interval"`
+	IncrementalBackupIntervalStr string             `json:"incremental_backup_interval"`
+	Compression                  *CompressionConfig `json:"compression"`
+	Replica                      *ReplicaConfig     `json:"replica"`
+
+	FullBackupInterval        time.Duration `json:"-"`
+	IncrementalBackupInterval time.Duration `json:"-"`
+}
+
+var targetNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// The values of --ssl-mode accepted by xtrabackup.
+var mysqlSSLModes = []string{"DISABLED", "PREFERRED", "REQUIRED", "VERIFY_CA", "VERIFY_IDENTITY"}
+
+// Set up the configured targets. Without a targets list, the top-level settings define a single
+// target named "default" that keeps the directory layout of earlier versions.
+func initializeTargets() error {
+	if len(config.Targets) == 0 {
+		config.Targets = []*Target{{Name: defaultTargetName, BackupDir: config.BackupDir, DownloadDir: config.DownloadDir}}
+	}
+	seen := make(map[string]bool)
+	for _, target := range config.Targets {
+		if !targetNameRegexp.MatchString(target.Name) {
+			return fmt.Errorf("invalid target name %q, use lowercase letters, digits, '-' and '_'", target.Name)
+		}
+		if seen[target.Name] {
+			return fmt.Errorf("duplicate target name %q", target.Name)
+		}
+		seen[target.Name] = true
+		err := target.normalize()
+		if err != nil {
+			return fmt.Errorf("target %q: %w", target.Name, err)
+		}
+	}
+	// Backups are named by their time only, so targets must not share a remote directory.
+	roots := make(map[string]string)
+	for _, target := range config.Targets {
+		root := targetRemoteLayout(target.Name).Root()
+		if other, ok := roots[root]; ok && !strings.Contains(target.RemotePath, "{target}") {
+			return fmt.Errorf("targets %q and %q store their backups in the same remote directory %s, add {target} to remote_path", other, target.Name, root)
+		}
+		roots[root] = target.Name
+	}
+	return nil
+}
+
+// Fill in the settings inherited from the top level and validate the target.
+func (t *Target) normalize() error {
+	t.MysqlUser = cmp.Or(t.MysqlUser, config.MysqlUser)
+	t.MysqlHost = cmp.Or(t.MysqlHost, config.MysqlHost)
+	t.MysqlPort = cmp.Or(t.MysqlPort, config.MysqlPort)
+	t.MysqlSocket = cmp.Or(t.MysqlSocket, config.MysqlSocket)
+	t.MysqlDefaultsFile = cmp.Or(t.MysqlDefaultsFile, config.MysqlDefaultsFile)
+	t.MysqlSSLMode = cmp.Or(t.MysqlSSLMode, config.MysqlSSLMode)
+	t.MysqlSSLCA = cmp.Or(t.MysqlSSLCA, config.MysqlSSLCA)
+	t.MysqlSSLCert = cmp.Or(t.MysqlSSLCert, config.MysqlSSLCert)
+	t.MysqlSSLKey = cmp.Or(t.MysqlSSLKey, config.MysqlSSLKey)
+	t.Datadir = cmp.Or(t.Datadir, config.Datadir)
+	t.LocalBackupCount = cmp.Or(t.LocalBackupCount, config.LocalBackupCount)
+	t.DefaultRCloneRemote = cmp.Or(t.DefaultRCloneRemote, config.DefaultRCloneRemote)
+	t.RemoteBackupCount = cmp.Or(t.RemoteBackupCount, config.RemoteBackupCount)
+	t.RemotePath = cmp.Or(t.RemotePath, config.RemotePath)
+
+	if t.RemoteBackupCount < 0 {
+		return fmt.Errorf("invalid remote_backup_count %d, must not be negative", t.RemoteBackupCount)
+	}
+	var err error
+	err = t.initializePaths()
+	if err != nil {
+		return err
+	}
+	err = validateRemotePath(t.RemotePath)
+	if err != nil {
+		return err
+	}
+	err = t.validateConnection()
+	if err != nil {
+		return err
+	}
+	t.MysqlPassword, err = loadMysqlPassword(t)
+	if err != nil {
+		return err
+	}
+	if t.MysqlPassword == "" {
+		return fmt.Errorf("no MySQL password configured, set %s, %s or mysql_password_file", mysqlPasswordEnv, mysqlPasswordFileEnv)
+	}
+	if t.MysqlPassword == insecureMysqlPassword {
+		return fmt.Errorf("refusing to use the default MySQL password, configure the password of the backup user")
+	}
+
+	t.FullBackupInterval = config.FullBackupInterval
+	if t.FullBackupIntervalStr != "" {
+		t.FullBackupInterval, err = time.ParseDuration(t.FullBackupIntervalStr)
+		if err != nil || t.FullBackupInterval <= 0 {
+			return fmt.Errorf("invalid full_backup_interval: %q, must be a positive duration", t.FullBackupIntervalStr)
+		}
+	}
+	t.IncrementalBackupInterval = config.IncrementalBackupInterval
+	if t.IncrementalBackupIntervalStr != "" {
+		t.IncrementalBackupInterval, err = time.ParseDuration(t.IncrementalBackupIntervalStr)
+		if err != nil || t.IncrementalBackupInterval <= 0 {
+			return fmt.Errorf("invalid incremental_backup_interval: %q, must be a positive duration", t.IncrementalBackupIntervalStr)
+		}
+	}
+
+	if t.Replica == nil {
+		t.Replica = &config.Replica
+	} else {
+		err = t.Replica.normalize()
+		if err != nil {
+			return fmt.Errorf("invalid replica config: %w", err)
+		}
+	}
+
+	if t.Compression == nil {
+		t.Compression = &config.Compression
+		return nil
+	}
+	err = t.Compression.Full.normalize()
+	if err != nil {
+		return fmt.Errorf("invalid full backup compression: %w", err)
+	}
+	err = t.Compression.Incremental.normalize()
+	if err != nil {
+		return fmt.Errorf("invalid incremental backup compression: %w", err)
+	}
+	return nil
+}
+
+// Fill in the default directories of the target, create them and check that the data directory exists.
+func (t *Target) initializePaths() error {
+	var err error
+	t.BackupDir, err = dirPath("backup_dir", cmp.Or(t.BackupDir, config.BackupDir+t.Name+"/"))
+	if err != nil {
+		return err
+	}
+	t.DownloadDir, err = dirPath("download_dir", cmp.Or(t.DownloadDir, config.DownloadDir+t.Name+"/"))
+	if err != nil {
+		return err
+	}
+	for _, dir := range []string{t.BackupDir, t.DownloadDir} {
+		err = os.MkdirAll(dir, 0o755)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", dir, err)
+		}
+	}
+	info, err := os.Stat(t.Datadir)
+	if err != nil {
+		return fmt.Errorf("datadir: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("datadir: %s is not a directory", t.Datadir)
+	}
+	return nil
+}
+
+// Check the connection options of the target.
+func (t *Target) validateConnection() error {
+	if t.MysqlSocket != "" && !filepath.IsAbs(t.MysqlSocket) {
+		return fmt.Errorf("mysql_socket must be an absolute path, got %q", t.MysqlSocket)
+	}
+	if t.MysqlSocket == "" && (t.MysqlPort < 1 || t.MysqlPort > 65535) {
+		return fmt.Errorf("mysql_port must be between 1 and 65535, got %d", t.MysqlPort)
+	}
+	if t.MysqlDefaultsFile != "" {
+		err := checkReadable("mysql_defaults_file", t.MysqlDefaultsFile)
+		if err != nil {
+			return err
+		}
+	}
+	if t.MysqlSSLMode != "" && !slices.Contains(mysqlSSLModes, t.MysqlSSLMode) {
+		return fmt.Errorf("invalid mysql_ssl_mode %q, expected one of %s", t.MysqlSSLMode, strings.Join(mysqlSSLModes, ", "))
+	}
+	if (t.MysqlSSLCert == "") != (t.MysqlSSLKey == "") {
+		return fmt.Errorf("mysql_ssl_cert and mysql_ssl_key must be set together")
+	}
+	files := []struct{ name, path string }{
+		{"mysql_ssl_ca", t.MysqlSSLCA},
+		{"mysql_ssl_cert", t.MysqlSSLCert},
+		{"mysql_ssl_key", t.MysqlSSLKey},
+	}
+	for _, file := range files {
+		if file.path == "" {
+			continue
+		}
+		err := checkReadable(file.name, file.path)
+		if err != nil {
+			return err
+		}
+	}
+	if (t.MysqlSSLMode == "VERIFY_CA" || t.MysqlSSLMode == "VERIFY_IDENTITY") && t.MysqlSSLCA == "" {
+		return fmt.Errorf("mysql_ssl_mode %s requires mysql_ssl_ca", t.MysqlSSLMode)
+	}
+	return nil
+}
+
+// The name of a scheduled job of this target, e.g. "main/full".
+func (t *Target) ScheduleName(job string) string {
+	return t.Name + "/" + job
+}
+
+// Get a target by name, or nil if it is not configured.
+func GetTarget(name string) *Target {
+	for _, target := range config.Targets {
+		if target.Name == name {
+			return target
+		}
+	}
+	return nil
+}
+
+// The target used by requests that do not name one: the first configured target.
+func DefaultTarget() *Target {
+	return config.Targets[0]
+}
+
+// The local backup directory of a target, also for targets removed from the config.
+func targetBackupDir(name string) string {
+	if target := GetTarget(name); target != nil {
+		return target.BackupDir
+	}
+	// The default target of a config without a targets list keeps its backups in backup_dir itself.
+	if name == defaultTargetName {
+		return config.BackupDir
+	}
+	return config.BackupDir + name + "/"
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestTargetBackupDir(t *testing.T) {
+	defer func(backupDir string, targets []*Target) {
+		config.BackupDir, config.Targets = backupDir, targets
+	}(config.BackupDir, config.Targets)
+	config.BackupDir = "/backup/"
+	config.Targets = []*Target{{Name: "main", BackupDir: "/data/main/"}}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"main", "/data/main/"},
+		{"removed", "/backup/removed/"},
+		{defaultTargetName, "/backup/"},
+	}
+	for _, test := range tests {
+		got := targetBackupDir(test.name)
+		if got != test.want {
+			t.Errorf("targetBackupDir(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+// Limit the disk IO of xtrabackup and the bandwidth of rclone, with profiles for times of day.
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Throttling settings. Zero values leave the limit to xtrabackup and rclone.
+type Throttle struct {
+	// IO operations per second of xtrabackup, passed as --throttle.
+	XtrabackupThrottle int `json:"xtrabackup_throttle,omitempty"`
+	// Bandwidth limit of rclone, passed as --bwlimit, e.g. "10M" or "10M:100M" for upload:download.
+	BwLimit string `json:"bwlimit,omitempty"`
+	// Parallel file transfers of rclone, passed as --transfers.
+	Transfers int `json:"transfers,omitempty"`
+}
+
+// Throttling settings that apply inside a time window, in the same format as blackout windows.
+type ThrottleProfile struct {
+	Name string `json:"name"`
+	BlackoutWindow
+	Throttle
+}
+
+type ThrottleConfig struct {
+	// The settings outside of all profiles.
+	Throttle
+	// The first profile whose window contains the start of an operation overrides the settings it sets.
+	Profiles []*ThrottleProfile `json:"profiles"`
+}
+
+// e.g. "off", "512k", "10M" or "10M:off"
+var bwLimitRegexp = regexp.MustCompile(`(?i)^(off|\d+(\.\d+)?[bkmgtp]?)(:(off|\d+(\.\d+)?[bkmgtp]?))?$`)
+
+func (t Throttle) validate() error {
+	if t.XtrabackupThrottle < 0 {
+		return fmt.Errorf("xtrabackup_throttle must not be negative")
+	}
+	if t.Transfers < 0 {
+		return fmt.Errorf("transfers must not be negative")
+	}
+	if t.BwLimit != "" && !bwLimitRegexp.MatchString(t.BwLimit) {
+		return fmt.Errorf("invalid bwlimit %q", t.BwLimit)
+	}
+	return nil
+}
+
+func (c *ThrottleConfig) validate() error {
+	err := c.Throttle.validate()
+	if err != nil {
+		return err
+	}
+	for i, profile := range c.Profiles {
+		profile.Name = cmp.Or(profile.Name, "#"+strconv.Itoa(i+1))
+		err = profile.BlackoutWindow.parse()
+		if err != nil {
+			return fmt.Errorf("profile %s: %w", profile.Name, err)
+		}
+		err = profile.Throttle.validate()
+		if err != nil {
+			return fmt.Errorf("profile %s: %w", profile.Name, err)
+		}
+	}
+	return nil
+}
+
+// The throttling settings at t and the name of the active profile, empty if none is active.
+func ThrottleAt(t time.Time) (Throttle, string) {
+	throttle := config.Throttle.Throttle
+	for _, profile := range config.Throttle.Profiles {
+		if _, ok := profile.activeAt(t); ok {
+			throttle.XtrabackupThrottle = cmp.Or(profile.XtrabackupThrottle, throttle.XtrabackupThrottle)
+			throttle.BwLimit = cmp.Or(profile.BwLimit, throttle.BwLimit)
+			throttle.Transfers = cmp.Or(profile.Transfers, throttle.Transfers)
+			return throttle, profile.Name
+		}
+	}
+	return throttle, ""
+}
+
+// The throttling settings for an operation starting now, noted in the log of the job ctx belongs to.
+// The settings apply until the operation finishes, even if it runs past the end of the profile.
+func currentThrottle(ctx context.Context) Throttle {
+	throttle, profile := ThrottleAt(time.Now())
+	if profile != "" {
+		JobLogf(ctx, "Using throttle profile %s", profile)
+	}
+	return throttle
+}
+
+func (t Throttle) xtrabackupArgs() []string {
+	if t.XtrabackupThrottle == 0 {
+		return nil
+	}
+	return []string{"--throttle=" + strconv.Itoa(t.XtrabackupThrottle)}
+}
+
+// The bandwidth limits of bwlimit for uploads and downloads in bytes per second, zero if unlimited.
+func (t Throttle) bandwidth() (int64, int64) {
+	if t.BwLimit == "" {
+		return 0, 0
+	}
+	upload, download, found := strings.Cut(t.BwLimit, ":")
+	if !found {
+		download = upload
+	}
+	return parseBandwidth(upload), parseBandwidth(download)
+}
+
+// Parse a bandwidth in the format of rclone: in KiB/s, unless suffixed with b, k, M, G, T or P.
+func parseBandwidth(s string) int64 {
+	if strings.EqualFold(s, "off") {
+		return 0
+	}
+	multiplier := float64(1 << 10)
+	if unit := strings.Index("bkmgtp", strings.ToLower(s[len(s)-1:])); unit >= 0 {
+		multiplier = float64(int64(1) << (10 * unit))
+		s = s[:len(s)-1]
+	}
+	value, _ := strconv.ParseFloat(s, 64)
+	return int64(value * multiplier)
+}
+
+// Format a bandwidth in bytes per second for rclone, off if unlimited.
+func formatBandwidth(bandwidth int64) string {
+	if bandwidth == 0 {
+		return "off"
+	}
+	return strconv.FormatInt(bandwidth, 10) + "b"
+}
+
+func (t Throttle) rcloneArgs() []string {
+	var args []string
+	if t.BwLimit != "" {
+		args = append(args, "--bwlimit", t.BwLimit)
+	}
+	if t.Transfers > 0 {
+		args = append(args, "--transfers", strconv.Itoa(t.Transfers))
+	}
+	return args
+}
@@ -0,0 +1,60 @@
+package main
+
+import "testing"
+
+func TestParseBandwidth(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int64
+	}{
+		{"off", 0},
+		{"OFF", 0},
+		{"0", 0},
+		{"10", 10 << 10},
+		{"512b", 512},
+		{"512B", 512},
+		{"10k", 10 << 10},
+		{"10M", 10 << 20},
+		{"1.5M", 3 << 19},
+		{"2G", 2 << 30},
+		{"1T", 1 << 40},
+		{"1P", 1 << 50},
+	}
+	for _, test := range tests {
+		got := parseBandwidth(test.s)
+		if got != test.want {
+			t.Errorf("parseBandwidth(%q) = %d, want %d", test.s, got, test.want)
+		}
+	}
+}
+
+func TestFormatBandwidth(t *testing.T) {
+	for _, bandwidth := range []int64{0, 1, 10 << 10, 3 << 19, 2 << 30} {
+		s := formatBandwidth(bandwidth)
+		if bandwidth == 0 && s != "off" {
+			t.Errorf("formatBandwidth(0) = %q, want off", s)
+		}
+		if got := parseBandwidth(s); got != bandwidth {
+			t.Errorf("parseBandwidth(formatBandwidth(%d)) = %d", bandwidth, got)
+		}
+	}
+}
+
+func TestThrottleBandwidth(t *testing.T) {
+	tests := []struct {
+		bwLimit  string
+		upload   int64
+		download int64
+	}{
+		{"", 0, 0},
+		{"10M", 10 << 20, 10 << 20},
+		{"10M:100M", 10 << 20, 100 << 20},
+		{"off:1M", 0, 1 << 20},
+	}
+	for _, test := range tests {
+		upload, download := Throttle{BwLimit: test.bwLimit}.bandwidth()
+		if upload != test.upload || download != test.download {
+			t.Errorf("bandwidth of %q = %d, %d, want %d, %d", test.bwLimit, upload, download, test.upload, test.download)
+		}
+	}
+}
@@ -2,8 +2,13 @@
 package main
 
 import (
+	"cmp"
 	"database/sql"
+	"encoding/json"
+	"fmt"
 	"log"
+	"path/filepath"
+	"slices"
 	"time"
 
 	_ "github.com/ncruces/go-sqlite3/driver"
@@ -21,9 +26,33 @@ const (
 	Archived
 )
 
+var statusNames = []string{"saved", "uploaded", "archived"}
+
+func (s Status) String() string {
+	if int(s) < len(statusNames) {
+		return statusNames[s]
+	}
+	return fmt.Sprintf("Status(%d)", int(s))
+}
+
+func (s Status) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func ParseStatus(s string) (Status, error) {
+	for i, name := range statusNames {
+		if name == s {
+			return Status(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown backup status %q", s)
+}
+
 type DatabaseTrack struct {
 	// The primary key ID.
-	ID int
+	ID int64
+	// The name of the target the backup was taken of.
+	Target string
 	// The backup time, saved in ISO 8601 format.
 	BackupTime time.Time
 	// The status of this backup.
@@ -32,6 +61,24 @@ type DatabaseTrack struct {
 	Type string
 	// Optional comment.
 	Comment string
+	// The remote object of a streamed backup, empty for backups staged locally.
+	RemoteObject string
+	// The ID of the key the backup is encrypted with, empty if it is not encrypted.
+	EncryptionKeyID string
+	// Whether the backup is encrypted with a key that is not known, e.g. a backup tracked by rebuilding
+	// the catalog from a remote; the key must be given on download.
+	EncryptionKeyUnknown bool
+	// The compression algorithm of the backup: zstd, lz4 or none.
+	Compression string
+	// The binlog coordinates and GTID set at the time of the backup.
+	Binlog BinlogInfo
+	// The files of the backup with their SHA-256 hashes, nil for backups tracked before manifests.
+	Manifest Manifest
+}
+
+// Whether the backup was streamed to the remote, leaving only its checkpoint metadata locally.
+func (track DatabaseTrack) IsStreamed() bool {
+	return track.RemoteObject != ""
 }
 
 func (track DatabaseTrack) IsFullBackup() bool {
@@ -44,10 +91,18 @@ func (track DatabaseTrack) IsIncrementalBackup() bool {
 
 func (track DatabaseTrack) GetBackupPath() string {
 	if track.IsFullBackup() {
-		return FormatFullBackupDir(track.BackupTime)
+		return FormatFullBackupDir(targetBackupDir(track.Target), track.BackupTime)
 	} else {
-		return FormatIncrementalBackupDir(track.BackupTime)
+		return FormatIncrementalBackupDir(targetBackupDir(track.Target), track.BackupTime)
+	}
+}
+
+// The name of the backup on the remote, as passed to the download API.
+func (track DatabaseTrack) BackupName() string {
+	if track.IsStreamed() {
+		return filepath.Base(track.RemoteObject)
 	}
+	return filepath.Base(track.GetBackupPath())
 }
 
 type Tracker struct {
@@ -57,7 +112,7 @@ type Tracker struct {
 var tracker *Tracker
 
 func InitializeTracker() {
-	db, err := sql.Open("sqlite3", sqliteDBPath)
+	db, err := sql.Open("sqlite3", config.DatabasePath)
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -76,9 +131,130 @@ func initializeTrackingDB(db *Tracker) error {
 		backup_time TEXT NOT NULL,
 		status INTEGER NOT NULL,
 		type TEXT NOT NULL,
-		comment TEXT
+		comment TEXT,
+		remote_object TEXT,
+		encryption_key_id TEXT,
+		encryption_key_unknown INTEGER NOT NULL DEFAULT 0,
+		compression TEXT,
+		target TEXT NOT NULL DEFAULT 'default',
+		binlog_file TEXT,
+		binlog_position INTEGER,
+		gtid_executed TEXT,
+		source_host TEXT,
+		source_log_file TEXT,
+		source_log_position INTEGER,
+		manifest TEXT
+	);
+	CREATE TABLE IF NOT EXISTS jobs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		kind TEXT NOT NULL,
+		job_key TEXT NOT NULL,
+		description TEXT,
+		state INTEGER NOT NULL,
+		error TEXT,
+		created_at TEXT NOT NULL,
+		started_at TEXT,
+		finished_at TEXT,
+		recovered_at TEXT,
+		log_path TEXT,
+		target TEXT
+	);
+	CREATE TABLE IF NOT EXISTS uploads (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		backup_id INTEGER NOT NULL REFERENCES backups (id),
+		remote TEXT NOT NULL,
+		remote_path TEXT,
+		state INTEGER NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT,
+		next_attempt_at TEXT NOT NULL,
+		created_at TEXT NOT NULL,
+		updated_at TEXT NOT NULL
+	);
+	CREATE TABLE IF NOT EXISTS scrubs (
+		backup_id INTEGER NOT NULL REFERENCES backups (id),
+		location TEXT NOT NULL,
+		verified_at TEXT NOT NULL,
+		outcome TEXT NOT NULL,
+		error TEXT,
+		PRIMARY KEY (backup_id, location)
+	);
+	CREATE TABLE IF NOT EXISTS scheduler_pauses (
+		job TEXT PRIMARY KEY,
+		reason TEXT,
+		paused_at TEXT NOT NULL,
+		resume_at TEXT
 	);
 	`)
+	if err != nil {
+		return err
+	}
+	err = db.addColumnIfMissing("jobs", "recovered_at", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = db.addColumnIfMissing("jobs", "log_path", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = db.addColumnIfMissing("backups", "remote_object", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = db.addColumnIfMissing("backups", "encryption_key_id", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = db.addColumnIfMissing("backups", "encryption_key_unknown", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+	err = db.addColumnIfMissing("backups", "compression", "TEXT")
+	if err != nil {
+		return err
+	}
+	// Backups tracked before targets were introduced belong to the default target.
+	err = db.addColumnIfMissing("backups", "target", "TEXT NOT NULL DEFAULT '"+defaultTargetName+"'")
+	if err != nil {
+		return err
+	}
+	err = db.addColumnIfMissing("jobs", "target", "TEXT")
+	if err != nil {
+		return err
+	}
+	binlogColumns := []struct{ name, definition string }{
+		{"binlog_file", "TEXT"},
+		{"binlog_position", "INTEGER"},
+		{"gtid_executed", "TEXT"},
+		{"source_host", "TEXT"},
+		{"source_log_file", "TEXT"},
+		{"source_log_position", "INTEGER"},
+	}
+	for _, column := range binlogColumns {
+		err = db.addColumnIfMissing("backups", column.name, column.definition)
+		if err != nil {
+			return err
+		}
+	}
+	err = db.addColumnIfMissing("backups", "manifest", "TEXT")
+	if err != nil {
+		return err
+	}
+	err = db.addColumnIfMissing("uploads", "remote_path", "TEXT")
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Add a column to a table created by an older version of the service.
+func (t *Tracker) addColumnIfMissing(table string, column string, definition string) error {
+	var count int
+	err := t.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&count)
+	if err != nil || count > 0 {
+		return err
+	}
+	_, err = t.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
 	return err
 }
 
@@ -86,23 +262,67 @@ func (t *Tracker) Close() error {
 	return t.DB.Close()
 }
 
-// Track a new backup in the database.
-func (t *Tracker) TrackBackup(backupTime time.Time, status Status, backupType string, comment string) error {
-	_, err := t.Exec("INSERT INTO backups (backup_time, status, type, comment) VALUES (?, ?, ?, ?)", backupTime.Format(time.RFC3339), status, backupType, comment)
+// Track a new backup in the database and return its ID.
+func (t *Tracker) TrackBackup(track DatabaseTrack) (int64, error) {
+	manifest, err := formatManifest(track.Manifest)
+	if err != nil {
+		return 0, err
+	}
+	result, err := t.Exec(
+		"INSERT INTO backups (target, backup_time, status, type, comment, remote_object, encryption_key_id, encryption_key_unknown, compression, "+
+			"binlog_file, binlog_position, gtid_executed, source_host, source_log_file, source_log_position, manifest) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		track.Target, track.BackupTime.Format(time.RFC3339), track.Status, track.Type, track.Comment,
+		sql.NullString{String: track.RemoteObject, Valid: track.RemoteObject != ""},
+		sql.NullString{String: track.EncryptionKeyID, Valid: track.EncryptionKeyID != ""},
+		track.EncryptionKeyUnknown,
+		track.Compression,
+		sql.NullString{String: track.Binlog.BinlogFile, Valid: track.Binlog.BinlogFile != ""},
+		sql.NullInt64{Int64: track.Binlog.BinlogPosition, Valid: track.Binlog.BinlogFile != ""},
+		sql.NullString{String: track.Binlog.GTIDExecuted, Valid: track.Binlog.GTIDExecuted != ""},
+		sql.NullString{String: track.Binlog.SourceHost, Valid: track.Binlog.SourceHost != ""},
+		sql.NullString{String: track.Binlog.SourceLogFile, Valid: track.Binlog.SourceLogFile != ""},
+		sql.NullInt64{Int64: track.Binlog.SourceLogPosition, Valid: track.Binlog.SourceLogFile != ""},
+		manifest,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+// Store the manifest of a backup tracked without one.
+func (t *Tracker) SetBackupManifest(id int64, manifest Manifest) error {
+	value, err := formatManifest(manifest)
+	if err != nil {
+		return err
+	}
+	_, err = t.Exec("UPDATE backups SET manifest = ? WHERE id = ?", value, id)
 	return err
 }
 
+// The manifest as JSON, NULL if there is none.
+func formatManifest(manifest Manifest) (sql.NullString, error) {
+	if manifest == nil {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
 // Update the status of a backup.
-func (t *Tracker) UpdateBackupStatus(backupTime time.Time, status Status) error {
-	_, err := t.Exec("UPDATE backups SET status = ? WHERE backup_time = ?", status, backupTime.Format(time.RFC3339))
+func (t *Tracker) UpdateBackupStatus(id int64, status Status) error {
+	_, err := t.Exec("UPDATE backups SET status = ? WHERE id = ?", status, id)
 	return err
 }
 
-// Get the last backup time.
-func (t *Tracker) GetLastBackupTime() (time.Time, bool, error) {
+// Get the last backup time of a target.
+func (t *Tracker) GetLastBackupTime(target string) (time.Time, bool, error) {
 	var backupTimeStr string
 	var backupType string
-	err := t.QueryRow("SELECT backup_time, type FROM backups ORDER BY backup_time DESC LIMIT 1").Scan(&backupTimeStr, &backupType)
+	err := t.QueryRow("SELECT backup_time, type FROM backups WHERE target = ? ORDER BY backup_time DESC LIMIT 1", target).Scan(&backupTimeStr, &backupType)
 	if err != nil {
 		return time.Time{}, false, err
 	}
@@ -113,90 +333,714 @@ func (t *Tracker) GetLastBackupTime() (time.Time, bool, error) {
 	return backupTime, backupType == "incremental", nil
 }
 
-// Get old full backups that exceed the local backup count and not uploaded.
-func (t *Tracker) GetOldBackups() ([]DatabaseTrack, error) {
-	rows, err := t.Query("SELECT id, backup_time, status, type, comment FROM backups WHERE type = 'full' AND status = 0 ORDER BY backup_time ASC")
+// Get old full backups of a target that exceed its local backup count and are not archived yet.
+func (t *Tracker) GetOldBackups(target *Target) ([]DatabaseTrack, error) {
+	allBackups, err := t.queryBackups("WHERE target = ? AND type = 'full' AND status != ? ORDER BY backup_time ASC", target.Name, Archived)
+	if err != nil {
+		return nil, err
+	}
+	if len(allBackups) <= target.LocalBackupCount {
+		return []DatabaseTrack{}, nil
+	}
+	backups := allBackups[:len(allBackups)-target.LocalBackupCount]
+	return backups, nil
+}
+
+// Get incremental backups associated with a full backup.
+func (t *Tracker) GetIncrementalTracks(parentTrack DatabaseTrack) ([]DatabaseTrack, error) {
+	var nextParentTimeStr string
+	err := t.QueryRow("SELECT backup_time FROM backups WHERE target = ? AND type = 'full' AND backup_time > ? ORDER BY backup_time ASC LIMIT 1", parentTrack.Target, parentTrack.BackupTime.Format(time.RFC3339)).Scan(&nextParentTimeStr)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if nextParentTimeStr == "" {
+		nextParentTimeStr = time.Now().Format(time.RFC3339)
+	}
+	return t.queryBackups("WHERE target = ? AND type = 'incremental' AND backup_time > ? AND backup_time < ? ORDER BY backup_time ASC", parentTrack.Target, parentTrack.BackupTime.Format(time.RFC3339), nextParentTimeStr)
+}
+
+// Get a backup by ID. Returns sql.ErrNoRows if it is not tracked.
+func (t *Tracker) GetBackup(id int64) (DatabaseTrack, error) {
+	backups, err := t.queryBackups("WHERE id = ?", id)
+	if err != nil {
+		return DatabaseTrack{}, err
+	}
+	if len(backups) == 0 {
+		return DatabaseTrack{}, sql.ErrNoRows
+	}
+	return backups[0], nil
+}
+
+// Get all tracked backups.
+func (t *Tracker) GetAllBackups() ([]DatabaseTrack, error) {
+	return t.queryBackups("ORDER BY backup_time ASC")
+}
+
+// Get all tracked backups of a target.
+func (t *Tracker) GetTargetBackups(target string) ([]DatabaseTrack, error) {
+	return t.queryBackups("WHERE target = ? ORDER BY backup_time ASC", target)
+}
+
+// Filters, sorting and pagination of listed backups.
+type BackupFilter struct {
+	// Only backups of this target if not empty.
+	Target string
+	// Only backups of this type if not empty: full or incremental.
+	Type      string
+	Status    Status
+	HasStatus bool
+	// Only backups taken at or after From and before To, if not zero.
+	From time.Time
+	To   time.Time
+	// Only backups whose comment contains Label if not empty.
+	Label string
+	// Only backups uploaded or queued for upload to this remote, or streamed to it, if not empty.
+	Remote string
+	// The column to sort by: backup_time, id, type or status.
+	Sort       string
+	Descending bool
+	Limit      int
+	Offset     int
+}
+
+// The columns backups can be sorted by.
+var backupSortColumns = []string{"backup_time", "id", "type", "status"}
+
+// List the backups matching the filter, and the total number of matching backups.
+func (t *Tracker) ListBackups(filter BackupFilter) ([]DatabaseTrack, int, error) {
+	clause := "WHERE 1 = 1"
+	var args []any
+	if filter.Target != "" {
+		clause += " AND target = ?"
+		args = append(args, filter.Target)
+	}
+	if filter.Type != "" {
+		clause += " AND type = ?"
+		args = append(args, filter.Type)
+	}
+	if filter.HasStatus {
+		clause += " AND status = ?"
+		args = append(args, filter.Status)
+	}
+	// Backup times are stored with the offset at the time of the backup, so they are compared and sorted
+	// as Unix timestamps rather than as strings.
+	if !filter.From.IsZero() {
+		clause += " AND unixepoch(backup_time) >= ?"
+		args = append(args, filter.From.Unix())
+	}
+	if !filter.To.IsZero() {
+		clause += " AND unixepoch(backup_time) < ?"
+		args = append(args, filter.To.Unix())
+	}
+	if filter.Label != "" {
+		clause += " AND instr(comment, ?) > 0"
+		args = append(args, filter.Label)
+	}
+	if filter.Remote != "" {
+		remotes, placeholders := uploadRemotes(filter.Remote)
+		clause += " AND (id IN (SELECT backup_id FROM uploads WHERE remote IN (" + placeholders + ") AND state != ?) " +
+			"OR (substr(remote_object, 1, length(?)) = ? AND id NOT IN (SELECT backup_id FROM uploads WHERE remote IN (" + placeholders + ") AND state = ?)))"
+		args = append(args, remotes...)
+		args = append(args, UploadDeleted, filter.Remote, filter.Remote)
+		args = append(args, remotes...)
+		args = append(args, UploadDeleted)
+	}
+
+	var total int
+	err := t.QueryRow("SELECT COUNT(*) FROM backups "+clause, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !slices.Contains(backupSortColumns, filter.Sort) {
+		return nil, 0, fmt.Errorf("unknown sort column %q", filter.Sort)
+	}
+	order := "ASC"
+	if filter.Descending {
+		order = "DESC"
+	}
+	column := filter.Sort
+	if column == "backup_time" {
+		column = "unixepoch(backup_time)"
+	}
+	clause += " ORDER BY " + column + " " + order + ", id " + order + " LIMIT ? OFFSET ?"
+	backups, err := t.queryBackups(clause, append(args, filter.Limit, filter.Offset)...)
+	return backups, total, err
+}
+
+// Get the backup an incremental backup is based on: the previous backup of its target.
+// Returns sql.ErrNoRows if there is none.
+func (t *Tracker) GetParentBackup(track DatabaseTrack) (DatabaseTrack, error) {
+	backups, err := t.queryBackups("WHERE target = ? AND backup_time < ? ORDER BY backup_time DESC LIMIT 1", track.Target, track.BackupTime.Format(time.RFC3339))
+	if err != nil {
+		return DatabaseTrack{}, err
+	}
+	if len(backups) == 0 {
+		return DatabaseTrack{}, sql.ErrNoRows
+	}
+	return backups[0], nil
+}
+
+// Get the backups based on a backup: the next backup of its target, if it is incremental.
+func (t *Tracker) GetChildBackups(track DatabaseTrack) ([]DatabaseTrack, error) {
+	backups, err := t.queryBackups("WHERE target = ? AND backup_time > ? ORDER BY backup_time ASC LIMIT 1", track.Target, track.BackupTime.Format(time.RFC3339))
+	if err != nil || len(backups) == 0 || !backups[0].IsIncrementalBackup() {
+		return nil, err
+	}
+	return backups, nil
+}
+
+// Get a backup of a target by its directory name, e.g. db_20251130_1200_inc.
+// Returns sql.ErrNoRows if it is not tracked.
+func (t *Tracker) GetBackupByName(target string, name string) (DatabaseTrack, error) {
+	backups, err := t.GetTargetBackups(target)
+	if err != nil {
+		return DatabaseTrack{}, err
+	}
+	for _, backup := range backups {
+		if filepath.Base(backup.GetBackupPath()) == name {
+			return backup, nil
+		}
+	}
+	return DatabaseTrack{}, sql.ErrNoRows
+}
+
+// Query backups with the given WHERE/ORDER BY clause.
+func (t *Tracker) queryBackups(clause string, args ...any) ([]DatabaseTrack, error) {
+	rows, err := t.Query("SELECT id, target, backup_time, status, type, comment, remote_object, encryption_key_id, encryption_key_unknown, compression, "+
+		"binlog_file, binlog_position, gtid_executed, source_host, source_log_file, source_log_position, manifest FROM backups "+clause, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
 	var backups []DatabaseTrack
-	var allBackups []DatabaseTrack
 	for rows.Next() {
 		var bt DatabaseTrack
 		var backupTimeStr string
-		err := rows.Scan(&bt.ID, &backupTimeStr, &bt.Status, &bt.Type, &bt.Comment)
+		var remoteObject, encryptionKeyID, compression sql.NullString
+		var binlogFile, gtidExecuted, sourceHost, sourceLogFile, manifest sql.NullString
+		var binlogPosition, sourceLogPosition sql.NullInt64
+		err := rows.Scan(&bt.ID, &bt.Target, &backupTimeStr, &bt.Status, &bt.Type, &bt.Comment, &remoteObject, &encryptionKeyID, &bt.EncryptionKeyUnknown, &compression,
+			&binlogFile, &binlogPosition, &gtidExecuted, &sourceHost, &sourceLogFile, &sourceLogPosition, &manifest)
 		if err != nil {
 			return nil, err
 		}
+		bt.Binlog = BinlogInfo{
+			BinlogFile:        binlogFile.String,
+			BinlogPosition:    binlogPosition.Int64,
+			GTIDExecuted:      gtidExecuted.String,
+			SourceHost:        sourceHost.String,
+			SourceLogFile:     sourceLogFile.String,
+			SourceLogPosition: sourceLogPosition.Int64,
+		}
+		if manifest.Valid {
+			err = json.Unmarshal([]byte(manifest.String), &bt.Manifest)
+			if err != nil {
+				return nil, err
+			}
+		}
+		bt.RemoteObject = remoteObject.String
+		bt.EncryptionKeyID = encryptionKeyID.String
+		// Backups tracked before compression was configurable were always compressed with zstd.
+		bt.Compression = cmp.Or(compression.String, defaultCompressionAlgorithm)
 		bt.BackupTime, err = time.Parse(time.RFC3339, backupTimeStr)
 		if err != nil {
 			return nil, err
 		}
-		allBackups = append(allBackups, bt)
+		backups = append(backups, bt)
 	}
-	if len(allBackups) <= config.LocalBackupCount {
-		return []DatabaseTrack{}, nil
+	return backups, rows.Err()
+}
+
+// Persist the paused state of a scheduled job.
+func (t *Tracker) SavePauseState(job string, state PauseState) error {
+	_, err := t.Exec("INSERT OR REPLACE INTO scheduler_pauses (job, reason, paused_at, resume_at) VALUES (?, ?, ?, ?)", job, state.Reason, state.PausedAt.Format(time.RFC3339), formatNullTime(state.ResumeAt))
+	return err
+}
+
+// Remove the paused state of a scheduled job.
+func (t *Tracker) DeletePauseState(job string) error {
+	_, err := t.Exec("DELETE FROM scheduler_pauses WHERE job = ?", job)
+	return err
+}
+
+// Get the paused states of all paused scheduled jobs, keyed by job name.
+func (t *Tracker) GetPauseStates() (map[string]PauseState, error) {
+	rows, err := t.Query("SELECT job, reason, paused_at, resume_at FROM scheduler_pauses")
+	if err != nil {
+		return nil, err
 	}
-	backups = allBackups[:len(allBackups)-config.LocalBackupCount]
-	return backups, nil
+	defer rows.Close()
+
+	states := make(map[string]PauseState)
+	for rows.Next() {
+		var job, pausedAtStr string
+		var reason, resumeAtStr sql.NullString
+		err := rows.Scan(&job, &reason, &pausedAtStr, &resumeAtStr)
+		if err != nil {
+			return nil, err
+		}
+		state := PauseState{Reason: reason.String}
+		state.PausedAt, err = time.Parse(time.RFC3339, pausedAtStr)
+		if err != nil {
+			return nil, err
+		}
+		if resumeAtStr.Valid {
+			state.ResumeAt, err = time.Parse(time.RFC3339, resumeAtStr.String)
+			if err != nil {
+				return nil, err
+			}
+		}
+		states[job] = state
+	}
+	return states, nil
 }
 
-// Get incremental backups associated with a full backup.
-func (t *Tracker) GetIncrementalTracks(parentTrack DatabaseTrack) ([]DatabaseTrack, error) {
-	var nextParentTimeStr string
-	err := t.QueryRow("SELECT backup_time FROM backups WHERE type = 'full' AND backup_time > ? ORDER BY backup_time ASC LIMIT 1", parentTrack.BackupTime.Format(time.RFC3339)).Scan(&nextParentTimeStr)
-	if err != nil && err != sql.ErrNoRows {
+func formatNullTime(t time.Time) sql.NullString {
+	if t.IsZero() {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: t.Format(time.RFC3339), Valid: true}
+}
+
+// Track a newly submitted job and assign its ID.
+func (t *Tracker) TrackJob(job *Job) error {
+	result, err := t.Exec("INSERT INTO jobs (kind, job_key, description, state, created_at, target) VALUES (?, ?, ?, ?, ?, ?)", job.Kind.String(), job.Key, job.Description, job.State, job.CreatedAt.Format(time.RFC3339), sql.NullString{String: job.Target, Valid: job.Target != ""})
+	if err != nil {
+		return err
+	}
+	job.ID, err = result.LastInsertId()
+	return err
+}
+
+// Update the state, error and timestamps of a tracked job.
+func (t *Tracker) UpdateJob(job *Job) error {
+	var errStr sql.NullString
+	if job.Err != nil {
+		errStr = sql.NullString{String: job.Err.Error(), Valid: true}
+	}
+	_, err := t.Exec("UPDATE jobs SET state = ?, error = ?, started_at = ?, finished_at = ? WHERE id = ?", job.State, errStr, formatNullTime(job.StartedAt), formatNullTime(job.FinishedAt), job.ID)
+	return err
+}
+
+// Mark jobs left queued or running by a crash as interrupted.
+func (t *Tracker) MarkStaleJobsInterrupted() error {
+	_, err := t.Exec("UPDATE jobs SET state = ? WHERE state IN (?, ?)", JobInterrupted, JobQueued, JobRunning)
+	return err
+}
+
+// Get interrupted jobs that have not been recovered yet.
+func (t *Tracker) GetUnrecoveredJobs() ([]*Job, error) {
+	rows, err := t.Query("SELECT id, kind, job_key, description, target FROM jobs WHERE state = ? AND recovered_at IS NULL ORDER BY id ASC", JobInterrupted)
+	if err != nil {
 		return nil, err
 	}
-	if nextParentTimeStr == "" {
-		nextParentTimeStr = time.Now().Format(time.RFC3339)
+	defer rows.Close()
+
+	var jobs []*Job
+	for rows.Next() {
+		job := &Job{State: JobInterrupted}
+		var kind string
+		var description, target sql.NullString
+		err := rows.Scan(&job.ID, &kind, &job.Key, &description, &target)
+		if err != nil {
+			return nil, err
+		}
+		job.Target = target.String
+		job.Kind, err = ParseJobKind(kind)
+		if err != nil {
+			return nil, err
+		}
+		job.Description = description.String
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// Record that an interrupted job has been recovered.
+func (t *Tracker) MarkJobRecovered(id int64) error {
+	_, err := t.Exec("UPDATE jobs SET recovered_at = ? WHERE id = ?", time.Now().Format(time.RFC3339), id)
+	return err
+}
+
+// Record the log file of a job.
+func (t *Tracker) UpdateJobLogPath(id int64, path string) error {
+	_, err := t.Exec("UPDATE jobs SET log_path = ? WHERE id = ?", path, id)
+	return err
+}
+
+// Get the log file of a job. Returns sql.ErrNoRows if the job does not exist,
+// and an empty path if the job has no log.
+func (t *Tracker) GetJobLogPath(id int64) (string, error) {
+	var path sql.NullString
+	err := t.QueryRow("SELECT log_path FROM jobs WHERE id = ?", id).Scan(&path)
+	return path.String, err
+}
+
+// Filters for listing jobs.
+type JobFilter struct {
+	Kind     JobKind
+	HasKind  bool
+	State    JobState
+	HasState bool
+	// Only jobs of this target if not empty.
+	Target string
+	Limit  int
+}
+
+// List tracked jobs matching the filter, newest first.
+func (t *Tracker) ListJobs(filter JobFilter) ([]JobInfo, error) {
+	clause := "WHERE 1 = 1"
+	var args []any
+	if filter.HasKind {
+		clause += " AND kind = ?"
+		args = append(args, filter.Kind.String())
+	}
+	if filter.HasState {
+		clause += " AND state = ?"
+		args = append(args, filter.State)
+	}
+	if filter.Target != "" {
+		clause += " AND target = ?"
+		args = append(args, filter.Target)
+	}
+	clause += " ORDER BY id DESC LIMIT ?"
+	args = append(args, filter.Limit)
+	return t.queryJobs(clause, args...)
+}
+
+// Get a tracked job by ID. Returns sql.ErrNoRows if it does not exist.
+func (t *Tracker) GetJob(id int64) (JobInfo, error) {
+	jobs, err := t.queryJobs("WHERE id = ?", id)
+	if err != nil {
+		return JobInfo{}, err
+	}
+	if len(jobs) == 0 {
+		return JobInfo{}, sql.ErrNoRows
 	}
-	rows, err := t.Query("SELECT id, backup_time, status, type, comment FROM backups WHERE type = 'incremental' AND backup_time > ? AND backup_time < ? ORDER BY backup_time ASC", parentTrack.BackupTime.Format(time.RFC3339), nextParentTimeStr)
+	return jobs[0], nil
+}
+
+// Query jobs with the given WHERE/ORDER BY clause.
+func (t *Tracker) queryJobs(clause string, args ...any) ([]JobInfo, error) {
+	rows, err := t.Query("SELECT id, kind, target, description, state, error, created_at, started_at, finished_at FROM jobs "+clause, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var incTracks []DatabaseTrack
+	var jobs []JobInfo
 	for rows.Next() {
-		var bt DatabaseTrack
-		var backupTimeStr string
-		err := rows.Scan(&bt.ID, &backupTimeStr, &bt.Status, &bt.Type, &bt.Comment)
+		var job JobInfo
+		var state JobState
+		var target, description, errStr, startedAtStr, finishedAtStr sql.NullString
+		var createdAtStr string
+		err := rows.Scan(&job.ID, &job.Kind, &target, &description, &state, &errStr, &createdAtStr, &startedAtStr, &finishedAtStr)
 		if err != nil {
 			return nil, err
 		}
-		bt.BackupTime, err = time.Parse(time.RFC3339, backupTimeStr)
+		job.Target = target.String
+		job.Description = description.String
+		job.State = state.String()
+		job.Error = errStr.String
+		job.CreatedAt, err = time.Parse(time.RFC3339, createdAtStr)
+		if err != nil {
+			return nil, err
+		}
+		job.StartedAt, err = parseNullTime(startedAtStr)
 		if err != nil {
 			return nil, err
 		}
-		incTracks = append(incTracks, bt)
+		job.FinishedAt, err = parseNullTime(finishedAtStr)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
 	}
-	return incTracks, nil
+	return jobs, rows.Err()
 }
 
-// Get backups that are not yet uploaded.
-func (t *Tracker) GetPendingUploads() ([]DatabaseTrack, error) {
-	rows, err := t.Query("SELECT id, backup_time, status, type, comment FROM backups WHERE status = 0 ORDER BY backup_time ASC")
+func parseNullTime(s sql.NullString) (*time.Time, error) {
+	if !s.Valid {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, s.String)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Upload times are stored in UTC so that due uploads can be selected by comparing strings.
+func formatUploadTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// Queue the upload of a backup.
+func (t *Tracker) EnqueueUpload(backupID int64, remote string) error {
+	now := formatUploadTime(time.Now())
+	_, err := t.Exec("INSERT INTO uploads (backup_id, remote, state, attempts, next_attempt_at, created_at, updated_at) VALUES (?, ?, ?, 0, ?, ?, ?)", backupID, remote, UploadPending, now, now, now)
+	return err
+}
+
+// Get saved backups that have no upload queued, e.g. backups tracked by older versions.
+func (t *Tracker) GetBackupsWithoutUpload() ([]DatabaseTrack, error) {
+	return t.queryBackups("WHERE status = ? AND id NOT IN (SELECT backup_id FROM uploads) ORDER BY backup_time ASC", Saved)
+}
+
+// Whether a backup has an upload that is pending, running or dead, unless a later upload of the backup
+// to the same remote is done.
+func (t *Tracker) HasUndeliveredUpload(backupID int64) (bool, error) {
+	var count int
+	err := t.QueryRow("SELECT COUNT(*) FROM uploads u WHERE u.backup_id = ? AND u.state IN (?, ?, ?) "+
+		"AND NOT EXISTS (SELECT 1 FROM uploads d WHERE d.backup_id = u.backup_id AND d.remote = u.remote AND d.state = ? AND d.id > u.id)",
+		backupID, UploadPending, UploadRunning, UploadDead, UploadDone).Scan(&count)
+	return count > 0, err
+}
+
+// Get pending uploads whose next attempt is due.
+func (t *Tracker) GetDueUploads(now time.Time) ([]Upload, error) {
+	return t.queryUploads("WHERE u.state = ? AND u.next_attempt_at <= ? ORDER BY u.next_attempt_at ASC", UploadPending, formatUploadTime(now))
+}
+
+// Get an upload by ID. Returns sql.ErrNoRows if it does not exist.
+func (t *Tracker) GetUpload(id int64) (Upload, error) {
+	uploads, err := t.queryUploads("WHERE u.id = ?", id)
+	if err != nil {
+		return Upload{}, err
+	}
+	if len(uploads) == 0 {
+		return Upload{}, sql.ErrNoRows
+	}
+	return uploads[0], nil
+}
+
+// List uploads, newest first, optionally only those in the given state.
+func (t *Tracker) ListUploads(state *UploadState, limit int) ([]Upload, error) {
+	if state != nil {
+		return t.queryUploads("WHERE u.state = ? ORDER BY u.id DESC LIMIT ?", *state, limit)
+	}
+	return t.queryUploads("ORDER BY u.id DESC LIMIT ?", limit)
+}
+
+// Mark an upload as running and count the attempt.
+func (t *Tracker) StartUploadAttempt(id int64) error {
+	_, err := t.Exec("UPDATE uploads SET state = ?, attempts = attempts + 1, updated_at = ? WHERE id = ?", UploadRunning, formatUploadTime(time.Now()), id)
+	return err
+}
+
+// Record the path on the remote an upload writes the backup to.
+func (t *Tracker) SetUploadRemotePath(id int64, remotePath string) error {
+	_, err := t.Exec("UPDATE uploads SET remote_path = ? WHERE id = ?", remotePath, id)
+	return err
+}
+
+// Record the outcome of an upload attempt.
+func (t *Tracker) FinishUpload(id int64, state UploadState, lastError string, nextAttemptAt time.Time) error {
+	_, err := t.Exec("UPDATE uploads SET state = ?, last_error = ?, next_attempt_at = ?, updated_at = ? WHERE id = ?", state, sql.NullString{String: lastError, Valid: lastError != ""}, formatUploadTime(nextAttemptAt), formatUploadTime(time.Now()), id)
+	return err
+}
+
+// Put a running upload back into the queue without counting the attempt.
+func (t *Tracker) RequeueUpload(id int64, nextAttemptAt time.Time) error {
+	_, err := t.Exec("UPDATE uploads SET state = ?, attempts = MAX(attempts - 1, 0), next_attempt_at = ?, updated_at = ? WHERE id = ?", UploadPending, formatUploadTime(nextAttemptAt), formatUploadTime(time.Now()), id)
+	return err
+}
+
+// Requeue uploads left running by a shutdown or crash.
+func (t *Tracker) ResetRunningUploads() error {
+	now := formatUploadTime(time.Now())
+	_, err := t.Exec("UPDATE uploads SET state = ?, next_attempt_at = ?, updated_at = ? WHERE state = ?", UploadPending, now, now, UploadRunning)
+	return err
+}
+
+// Requeue an upload for an immediate attempt with a fresh attempt count.
+func (t *Tracker) RetryUpload(id int64) error {
+	now := formatUploadTime(time.Now())
+	_, err := t.Exec("UPDATE uploads SET state = ?, attempts = 0, next_attempt_at = ?, updated_at = ? WHERE id = ?", UploadPending, now, now, id)
+	return err
+}
+
+// Query uploads joined with their backups, with the given WHERE/ORDER BY clause on alias u.
+func (t *Tracker) queryUploads(clause string, args ...any) ([]Upload, error) {
+	rows, err := t.Query("SELECT u.id, u.backup_id, b.target, b.backup_time, b.type, u.remote, u.remote_path, u.state, u.attempts, u.last_error, u.next_attempt_at, u.created_at, u.updated_at FROM uploads u JOIN backups b ON b.id = u.backup_id "+clause, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var backups []DatabaseTrack
+	var uploads []Upload
 	for rows.Next() {
-		var bt DatabaseTrack
-		var backupTimeStr string
-		err := rows.Scan(&bt.ID, &backupTimeStr, &bt.Status, &bt.Type, &bt.Comment)
+		var u Upload
+		var backupTimeStr, nextAttemptAtStr, createdAtStr, updatedAtStr string
+		var lastError, remotePath sql.NullString
+		err := rows.Scan(&u.ID, &u.BackupID, &u.Target, &backupTimeStr, &u.BackupType, &u.Remote, &remotePath, &u.State, &u.Attempts, &lastError, &nextAttemptAtStr, &createdAtStr, &updatedAtStr)
 		if err != nil {
 			return nil, err
 		}
-		bt.BackupTime, err = time.Parse(time.RFC3339, backupTimeStr)
+		u.LastError = lastError.String
+		u.RemotePath = remotePath.String
+		for _, field := range []struct {
+			value  string
+			target *time.Time
+		}{
+			{backupTimeStr, &u.BackupTime},
+			{nextAttemptAtStr, &u.NextAttemptAt},
+			{createdAtStr, &u.CreatedAt},
+			{updatedAtStr, &u.UpdatedAt},
+		} {
+			*field.target, err = time.Parse(time.RFC3339, field.value)
+			if err != nil {
+				return nil, err
+			}
+		}
+		uploads = append(uploads, u)
+	}
+	return uploads, rows.Err()
+}
+
+// Get the uploads that completed, oldest first.
+func (t *Tracker) GetDoneUploads() ([]Upload, error) {
+	return t.queryUploads("WHERE u.state = ? ORDER BY u.id ASC", UploadDone)
+}
+
+// Get the uploads of a backup that completed, oldest first.
+func (t *Tracker) GetDoneUploadsOfBackup(backupID int64) ([]Upload, error) {
+	return t.queryUploads("WHERE u.backup_id = ? AND u.state = ? ORDER BY u.id ASC", backupID, UploadDone)
+}
+
+// Get the uploads whose copies were deleted by remote retention, oldest first.
+func (t *Tracker) GetDeletedUploads() ([]Upload, error) {
+	return t.queryUploads("WHERE u.state = ? ORDER BY u.id ASC", UploadDeleted)
+}
+
+// The values and placeholders of the remote column matching remote: uploads without a remote go to
+// the default remote.
+func uploadRemotes(remote string) ([]any, string) {
+	if remote == config.DefaultRCloneRemote {
+		return []any{remote, ""}, "?, ?"
+	}
+	return []any{remote}, "?"
+}
+
+// Record that the copy of a backup on a remote was deleted. Streamed backups and backups without an
+// upload record get a deleted upload record.
+func (t *Tracker) MarkRemoteCopyDeleted(backupID int64, remote string, remotePath string) error {
+	now := formatUploadTime(time.Now())
+	remotes, placeholders := uploadRemotes(remote)
+	result, err := t.Exec("UPDATE uploads SET state = ?, updated_at = ? WHERE backup_id = ? AND state = ? AND remote IN ("+placeholders+")",
+		append([]any{UploadDeleted, now, backupID, UploadDone}, remotes...)...)
+	if err != nil {
+		return err
+	}
+	if updated, err := result.RowsAffected(); err != nil || updated > 0 {
+		return err
+	}
+	_, err = t.Exec("INSERT INTO uploads (backup_id, remote, remote_path, state, attempts, next_attempt_at, created_at, updated_at) VALUES (?, ?, ?, ?, 0, ?, ?, ?)",
+		backupID, remote, remotePath, UploadDeleted, now, now, now)
+	return err
+}
+
+// Get the remotes that hold completed uploads of backups of a target. Uploads without a remote went to
+// the default remote and are listed as an empty string.
+func (t *Tracker) GetUploadRemotes(target string) ([]string, error) {
+	rows, err := t.Query("SELECT DISTINCT u.remote FROM uploads u JOIN backups b ON b.id = u.backup_id WHERE b.target = ? AND u.state = ? ORDER BY u.remote ASC", target, UploadDone)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var remotes []string
+	for rows.Next() {
+		var remote string
+		err := rows.Scan(&remote)
 		if err != nil {
 			return nil, err
 		}
-		backups = append(backups, bt)
+		remotes = append(remotes, remote)
 	}
-	return backups, nil
+	return remotes, rows.Err()
+}
+
+// Whether the tracker recorded a copy of a backup on a remote: a completed upload, a copy deleted by remote
+// retention or a backup streamed to the remote.
+func (t *Tracker) HasRemoteCopy(backupID int64, remote string) (bool, error) {
+	remotes, placeholders := uploadRemotes(remote)
+	var count int
+	err := t.QueryRow("SELECT COUNT(*) FROM backups WHERE id = ? AND (substr(remote_object, 1, length(?)) = ? "+
+		"OR id IN (SELECT backup_id FROM uploads WHERE remote IN ("+placeholders+") AND state IN (?, ?)))",
+		append(append([]any{backupID, remote, remote}, remotes...), UploadDone, UploadDeleted)...).Scan(&count)
+	return count > 0, err
+}
+
+// Record a copy of a backup found on a remote as a completed upload, e.g. when rebuilding the catalog.
+func (t *Tracker) TrackRemoteCopy(backupID int64, remote string, remotePath string) error {
+	now := formatUploadTime(time.Now())
+	_, err := t.Exec("INSERT INTO uploads (backup_id, remote, remote_path, state, attempts, next_attempt_at, created_at, updated_at) VALUES (?, ?, ?, ?, 0, ?, ?, ?)",
+		backupID, remote, remotePath, UploadDone, now, now, now)
+	return err
+}
+
+// Get the uploads of a backup in any state, oldest first.
+func (t *Tracker) GetUploadsOfBackup(backupID int64) ([]Upload, error) {
+	return t.queryUploads("WHERE u.backup_id = ? ORDER BY u.id ASC", backupID)
+}
+
+// Record the outcome of scrubbing a copy of a backup, replacing the previous outcome.
+func (t *Tracker) RecordScrub(result ScrubResult) error {
+	_, err := t.Exec("INSERT OR REPLACE INTO scrubs (backup_id, location, verified_at, outcome, error) VALUES (?, ?, ?, ?, ?)",
+		result.BackupID, result.Location, result.VerifiedAt.Format(time.RFC3339), result.Outcome, sql.NullString{String: result.Error, Valid: result.Error != ""})
+	return err
+}
+
+// List the last scrub outcome of every scrubbed copy, least recently verified first, optionally only
+// those with the given outcome.
+func (t *Tracker) ListScrubs(outcome string) ([]ScrubResult, error) {
+	clause := ""
+	var args []any
+	if outcome != "" {
+		clause = "WHERE s.outcome = ? "
+		args = append(args, outcome)
+	}
+	return t.queryScrubs(clause+"ORDER BY s.verified_at ASC, s.backup_id ASC", args...)
+}
+
+// Get the last scrub outcome of every scrubbed copy of a backup.
+func (t *Tracker) GetScrubsOfBackup(backupID int64) ([]ScrubResult, error) {
+	return t.queryScrubs("WHERE s.backup_id = ? ORDER BY s.location ASC", backupID)
+}
+
+// Query scrub outcomes joined with their backups, with the given WHERE/ORDER BY clause on alias s.
+func (t *Tracker) queryScrubs(clause string, args ...any) ([]ScrubResult, error) {
+	rows, err := t.Query("SELECT s.backup_id, b.target, b.backup_time, b.type, b.remote_object, s.location, s.verified_at, s.outcome, s.error "+
+		"FROM scrubs s JOIN backups b ON b.id = s.backup_id "+clause, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []ScrubResult
+	for rows.Next() {
+		var result ScrubResult
+		var track DatabaseTrack
+		var backupTimeStr, verifiedAtStr string
+		var remoteObject, scrubError sql.NullString
+		err := rows.Scan(&result.BackupID, &track.Target, &backupTimeStr, &track.Type, &remoteObject, &result.Location, &verifiedAtStr, &result.Outcome, &scrubError)
+		if err != nil {
+			return nil, err
+		}
+		track.BackupTime, err = time.Parse(time.RFC3339, backupTimeStr)
+		if err != nil {
+			return nil, err
+		}
+		result.VerifiedAt, err = time.Parse(time.RFC3339, verifiedAtStr)
+		if err != nil {
+			return nil, err
+		}
+		track.RemoteObject = remoteObject.String
+		result.Target = track.Target
+		result.BackupName = track.BackupName()
+		result.Error = scrubError.String
+		results = append(results, result)
+	}
+	return results, rows.Err()
 }
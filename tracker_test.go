@@ -0,0 +1,75 @@
+package main
+
+import (
+	"database/sql"
+	"slices"
+	"testing"
+	"time"
+)
+
+// A tracker on an in-memory database, restored to the previous tracker when the test ends.
+func newTestTracker(t *testing.T) *Tracker {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection to :memory: opens a database of its own.
+	db.SetMaxOpenConns(1)
+	previous := tracker
+	tracker = &Tracker{db}
+	t.Cleanup(func() {
+		tracker = previous
+		db.Close()
+	})
+	err = initializeTrackingDB(tracker)
+	if err != nil {
+		t.Fatalf("initializeTrackingDB: %v", err)
+	}
+	return tracker
+}
+
+func TestTrackerListBackupsTimeFilter(t *testing.T) {
+	tracker := newTestTracker(t)
+	east := time.FixedZone("UTC+8", 8*60*60)
+	// Stored with different offsets, so that comparing the stored strings would order them wrongly.
+	times := []time.Time{
+		time.Date(2025, 11, 30, 12, 0, 0, 0, time.UTC),
+		time.Date(2025, 11, 30, 21, 0, 0, 0, east),
+		time.Date(2025, 11, 30, 14, 0, 0, 0, time.UTC),
+		time.Date(2025, 12, 1, 6, 0, 0, 0, east),
+	}
+	for _, backupTime := range times {
+		_, err := tracker.TrackBackup(DatabaseTrack{Target: "main", BackupTime: backupTime, Status: Saved, Type: "full"})
+		if err != nil {
+			t.Fatalf("TrackBackup: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		from time.Time
+		to   time.Time
+		want []time.Time
+	}{
+		{"no filter", time.Time{}, time.Time{}, []time.Time{times[0], times[1], times[2], times[3]}},
+		{"from", times[1], time.Time{}, []time.Time{times[1], times[2], times[3]}},
+		{"to", time.Time{}, times[2], []time.Time{times[0], times[1]}},
+		{"from and to", times[1].Add(-time.Second), times[3], []time.Time{times[1], times[2]}},
+		{"empty range", times[3].Add(time.Second), time.Time{}, nil},
+	}
+	for _, test := range tests {
+		backups, total, err := tracker.ListBackups(BackupFilter{From: test.from, To: test.to, Sort: "backup_time", Limit: 100})
+		if err != nil {
+			t.Fatalf("%s: ListBackups: %v", test.name, err)
+		}
+		var got []time.Time
+		for _, backup := range backups {
+			got = append(got, backup.BackupTime)
+		}
+		equal := func(a time.Time, b time.Time) bool { return a.Equal(b) }
+		if total != len(test.want) || !slices.EqualFunc(got, test.want, equal) {
+			t.Errorf("%s: ListBackups = %v (total %d), want %v", test.name, got, total, test.want)
+		}
+	}
+}
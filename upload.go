@@ -0,0 +1,279 @@
+// Persistent upload queue with retries, exponential backoff and a dead-letter state.
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+type UploadState int
+
+const (
+	// The upload waits for its next attempt.
+	UploadPending UploadState = iota
+	// The upload is being executed.
+	UploadRunning
+	// The backup was uploaded successfully.
+	UploadDone
+	// The upload failed too many times and needs manual intervention.
+	UploadDead
+	// The uploaded copy was deleted from the remote by remote retention.
+	UploadDeleted
+)
+
+var uploadStateNames = []string{"pending", "running", "done", "dead", "deleted"}
+
+func (s UploadState) String() string {
+	if int(s) < len(uploadStateNames) {
+		return uploadStateNames[s]
+	}
+	return fmt.Sprintf("UploadState(%d)", int(s))
+}
+
+func (s UploadState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func ParseUploadState(s string) (UploadState, error) {
+	for i, name := range uploadStateNames {
+		if name == s {
+			return UploadState(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown upload state %q", s)
+}
+
+// An upload of a backup to a remote, persisted in the tracker.
+type Upload struct {
+	ID            int64       `json:"id"`
+	BackupID      int64       `json:"backup_id"`
+	Target        string      `json:"target"`
+	BackupTime    time.Time   `json:"backup_time"`
+	BackupType    string      `json:"backup_type"`
+	Remote        string      `json:"remote"`
+	RemotePath    string      `json:"remote_path,omitempty"`
+	State         UploadState `json:"state"`
+	Attempts      int         `json:"attempts"`
+	LastError     string      `json:"last_error,omitempty"`
+	NextAttemptAt time.Time   `json:"next_attempt_at"`
+	CreatedAt     time.Time   `json:"created_at"`
+	UpdatedAt     time.Time   `json:"updated_at"`
+}
+
+// The local directory of the uploaded backup.
+func (u Upload) GetBackupPath() string {
+	return DatabaseTrack{Target: u.Target, BackupTime: u.BackupTime, Type: u.BackupType}.GetBackupPath()
+}
+
+// The path of the backup on the remote. Uploads recorded without a path were stored at the path of
+// the local backup directory.
+func (u Upload) RemoteBackupPath() string {
+	return cmp.Or(u.RemotePath, u.GetBackupPath())
+}
+
+// The hook event of the upload, run as part of the job ctx belongs to.
+func (u Upload) hookEvent(ctx context.Context) HookEvent {
+	event := HookEvent{
+		Target:     u.Target,
+		BackupType: u.BackupType,
+		BackupTime: u.BackupTime,
+		BackupName: filepath.Base(u.GetBackupPath()),
+		BackupPath: u.GetBackupPath(),
+		Remote:     u.Remote,
+	}
+	if job := JobFromContext(ctx); job != nil {
+		event.JobID = job.ID
+	}
+	return event
+}
+
+var uploadDispatcherWake = make(chan struct{}, 1)
+
+// Wake the upload dispatcher, e.g. after a new upload was queued.
+func WakeUploadDispatcher() {
+	select {
+	case uploadDispatcherWake <- struct{}{}:
+	default:
+	}
+}
+
+// Queue the upload of a backup and wake the dispatcher.
+func EnqueueUpload(backupID int64, remote string) error {
+	err := tracker.EnqueueUpload(backupID, remote)
+	if err != nil {
+		return err
+	}
+	WakeUploadDispatcher()
+	return nil
+}
+
+// Start the dispatcher that submits due uploads to the job queue.
+func InitializeUploadDispatcher() {
+	err := tracker.ResetRunningUploads()
+	if err != nil {
+		log.Printf("Failed to reset interrupted uploads: %v", err)
+	}
+	go func() {
+		ticker := time.NewTicker(uploadDispatchInterval)
+		defer ticker.Stop()
+		for {
+			DispatchUploads()
+			select {
+			case <-ticker.C:
+			case <-uploadDispatcherWake:
+			}
+		}
+	}()
+}
+
+// Submit all due uploads to the job queue, unless uploads are paused or in a blackout window.
+func DispatchUploads() {
+	if IsSchedulePaused(ScheduleUpload) {
+		return
+	}
+	if _, _, active := InBlackout(time.Now()); active {
+		return
+	}
+	uploads, err := tracker.GetDueUploads(time.Now())
+	if err != nil {
+		log.Printf("Failed to load due uploads: %v", err)
+		return
+	}
+	for _, upload := range uploads {
+		_, err := SubmitUpload(upload)
+		if err != nil && err != ErrJobConflict {
+			log.Printf("Failed to queue upload #%d: %v", upload.ID, err)
+		}
+	}
+}
+
+// Submit an upload job. Only one job per upload can be queued at a time.
+func SubmitUpload(upload Upload) (*Job, error) {
+	key := "upload:" + strconv.FormatInt(upload.ID, 10)
+	description := fmt.Sprintf("Upload backup %s (upload #%d, attempt %d)", upload.BackupTime.Format(time.DateTime), upload.ID, upload.Attempts+1)
+	job := NewJob(JobUpload, key, description, func(ctx context.Context) error {
+		return runUpload(ctx, upload)
+	})
+	job.Target = upload.Target
+	job, err := jobQueue.Submit(job)
+	if err != nil {
+		return job, err
+	}
+	// An upload whose job is cancelled, while queued or running, is marked dead so that it is only retried
+	// through POST /uploads/{id}/retry. Jobs interrupted by a shutdown finish with ErrShuttingDown instead.
+	go func() {
+		if errors.Is(job.Wait(), context.Canceled) {
+			trackErr := tracker.FinishUpload(upload.ID, UploadDead, "Cancelled", time.Now())
+			if trackErr != nil {
+				log.Printf("Failed to record cancelled upload #%d: %v", upload.ID, trackErr)
+			}
+		}
+	}()
+	return job, nil
+}
+
+// Upload a backup and verify the uploaded files against its manifest. Backups tracked before manifests
+// get one written first.
+func uploadAndVerify(ctx context.Context, upload Upload) error {
+	track, err := tracker.GetBackup(upload.BackupID)
+	if err != nil {
+		return err
+	}
+	if track.Manifest == nil {
+		track.Manifest, err = WriteManifest(ctx, upload.GetBackupPath())
+		if err != nil {
+			return fmt.Errorf("Failed to write manifest of backup %s: %w", upload.GetBackupPath(), err)
+		}
+		err = tracker.SetBackupManifest(upload.BackupID, track.Manifest)
+		if err != nil {
+			return err
+		}
+	}
+	remotePath, err := targetRemoteLayout(upload.Target).Path(track.BackupName())
+	if err != nil {
+		return err
+	}
+	err = tracker.SetUploadRemotePath(upload.ID, remotePath)
+	if err != nil {
+		return err
+	}
+	err = UploadToRemote(ctx, upload.GetBackupPath(), remotePath, upload.Remote)
+	if err != nil {
+		return err
+	}
+	return VerifyUpload(ctx, cmp.Or(upload.Remote, config.DefaultRCloneRemote), remotePath, track.Manifest, false)
+}
+
+// Run one attempt of an upload and record its outcome.
+func runUpload(ctx context.Context, upload Upload) error {
+	upload.Attempts++
+	err := tracker.StartUploadAttempt(upload.ID)
+	if err != nil {
+		return err
+	}
+
+	err = uploadAndVerify(ctx, upload)
+	if err == nil {
+		err = tracker.UpdateBackupStatus(upload.BackupID, Uploaded)
+		if err != nil {
+			return err
+		}
+		err = tracker.FinishUpload(upload.ID, UploadDone, "", time.Now())
+		if err != nil {
+			return err
+		}
+		RunHooks(ctx, HookPostUpload, upload.hookEvent(ctx))
+		return nil
+	}
+
+	// Attempts interrupted by a shutdown do not count towards the attempt limit. Cancelled attempts are
+	// ended by SubmitUpload.
+	if errors.Is(err, context.Canceled) {
+		if errors.Is(context.Cause(ctx), ErrShuttingDown) {
+			trackErr := tracker.RequeueUpload(upload.ID, time.Now().Add(uploadBackoff(1)))
+			if trackErr != nil {
+				log.Printf("Failed to requeue upload #%d: %v", upload.ID, trackErr)
+			}
+		}
+		return err
+	}
+
+	if upload.Attempts >= config.UploadMaxAttempts {
+		trackErr := tracker.FinishUpload(upload.ID, UploadDead, err.Error(), time.Now())
+		if trackErr != nil {
+			log.Printf("Failed to record dead upload #%d: %v", upload.ID, trackErr)
+		}
+		SendAlert(
+			fmt.Sprintf("Upload of backup %s failed permanently", upload.BackupTime.Format(time.DateTime)),
+			fmt.Sprintf("Upload #%d to %q failed %d times, last error: %v", upload.ID, upload.Remote, upload.Attempts, err),
+		)
+		RunFailureHooks(ctx, upload.hookEvent(ctx), err)
+		return err
+	}
+
+	nextAttempt := time.Now().Add(uploadBackoff(upload.Attempts))
+	trackErr := tracker.FinishUpload(upload.ID, UploadPending, err.Error(), nextAttempt)
+	if trackErr != nil {
+		log.Printf("Failed to record failed upload #%d: %v", upload.ID, trackErr)
+	}
+	log.Printf("Upload #%d failed on attempt %d, retrying at %s\n", upload.ID, upload.Attempts, nextAttempt.Format(time.DateTime))
+	return err
+}
+
+// The delay before the next attempt after the given number of failed attempts: exponential,
+// capped at the configured maximum, with random jitter of up to half the delay.
+func uploadBackoff(attempts int) time.Duration {
+	delay := config.UploadRetryBase
+	for i := 1; i < attempts && delay < config.UploadRetryMax; i++ {
+		delay *= 2
+	}
+	delay = min(delay, config.UploadRetryMax)
+	return delay/2 + rand.N(delay/2+1)
+}
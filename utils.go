@@ -2,7 +2,14 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
 	"os/exec"
+	"strings"
+	"syscall"
 	"time"
 )
 
@@ -10,16 +17,143 @@ func FormatBackupTime(t time.Time) string {
 	return t.Format("20060102_1504")
 }
 
-func FormatFullBackupDir(t time.Time) string {
-	return backupPath + "db_" + FormatBackupTime(t)
+func FormatFullBackupDir(dir string, t time.Time) string {
+	return dir + "db_" + FormatBackupTime(t)
 }
 
-func FormatIncrementalBackupDir(t time.Time) string {
-	return backupPath + "db_" + FormatBackupTime(t) + "_inc"
+func FormatIncrementalBackupDir(dir string, t time.Time) string {
+	return dir + "db_" + FormatBackupTime(t) + "_inc"
 }
 
-func RunSubprocess(name string, args ...string) (string, error) {
-	cmd := exec.Command(name, args...)
-	output, err := cmd.CombinedOutput()
-	return string(output), err
+// Parse the name of a backup directory or streamed backup object, e.g. db_20251130_1200_inc.xbstream,
+// into its backup time and type, full or incremental.
+func ParseBackupName(name string) (time.Time, string, bool) {
+	rest, ok := strings.CutPrefix(strings.TrimSuffix(name, xbstreamSuffix), "db_")
+	if !ok {
+		return time.Time{}, "", false
+	}
+	backupType := "full"
+	if trimmed, ok := strings.CutSuffix(rest, "_inc"); ok {
+		rest, backupType = trimmed, "incremental"
+	}
+	backupTime, err := time.ParseInLocation("20060102_1504", rest, time.Local)
+	if err != nil {
+		return time.Time{}, "", false
+	}
+	return backupTime, backupType, true
+}
+
+// Run a subprocess and return the last lines of its combined output. The full output is streamed
+// to the log file of the job ctx belongs to. When ctx is done, the process receives SIGTERM
+// and is killed if it has not exited within subprocessKillDelay.
+func RunSubprocess(ctx context.Context, name string, args ...string) (string, error) {
+	tail := newTailBuffer(subprocessOutputTailLines)
+	cmd := newSubprocess(ctx, tail, name, args...)
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	return tail.String(), err
+}
+
+// Run a subprocess reading its standard input from stdin, like RunSubprocess.
+func RunSubprocessWithInput(ctx context.Context, stdin io.Reader, name string, args ...string) (string, error) {
+	tail := newTailBuffer(subprocessOutputTailLines)
+	cmd := newSubprocess(ctx, tail, name, args...)
+	cmd.Stdin = stdin
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	return tail.String(), err
+}
+
+// Run source and pass its standard output to sink, e.g. xtrabackup streaming to remote storage, and
+// return the last lines of the error output of source. If either fails, the other one is stopped as
+// well and the error of the one that failed first is returned.
+func RunPipeline(ctx context.Context, source []string, sink func(ctx context.Context, r io.Reader) error) (string, error) {
+	pipeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	tail := newTailBuffer(subprocessOutputTailLines)
+	sourceCmd := newSubprocess(pipeCtx, tail, source[0], source[1:]...)
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return "", err
+	}
+	sourceCmd.Stdout = writer
+	err = sourceCmd.Start()
+	writer.Close()
+	if err != nil {
+		reader.Close()
+		return "", err
+	}
+
+	type result struct {
+		name string
+		err  error
+	}
+	results := make(chan result, 2)
+	go func() { results <- result{source[0], sourceCmd.Wait()} }()
+	go func() {
+		err := sink(pipeCtx, reader)
+		// Unblock the source if the sink stopped reading early.
+		reader.Close()
+		results <- result{"", err}
+	}()
+	var first error
+	for range 2 {
+		r := <-results
+		if r.err != nil && first == nil {
+			first = r.err
+			if r.name != "" {
+				first = fmt.Errorf("%s: %w", r.name, r.err)
+			}
+			cancel()
+		}
+	}
+	if ctx.Err() != nil {
+		first = ctx.Err()
+	}
+	return tail.String(), first
+}
+
+// Create a command that is terminated when ctx is done and writes its output to tail, the log file
+// of the job ctx belongs to, and the output parser of ctx.
+func newSubprocess(ctx context.Context, tail io.Writer, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(syscall.SIGTERM)
+	}
+	cmd.WaitDelay = subprocessKillDelay
+
+	output := tail
+	if job := JobFromContext(ctx); job != nil && job.log != nil {
+		JobLogf(ctx, "$ %s", FormatCommand(name, args))
+		output = io.MultiWriter(job.log, tail)
+	}
+	if parse := outputParserFromContext(ctx); parse != nil {
+		output = io.MultiWriter(output, &lineWriter{parse: parse})
+	}
+	cmd.Stdout = output
+	cmd.Stderr = output
+	return cmd
+}
+
+// Derive a context that times out after timeout, or never if timeout is zero.
+func WithOptionalTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// Remove the output of an interrupted operation.
+func RemovePartialOutput(path string) {
+	err := os.RemoveAll(path)
+	if err != nil {
+		log.Printf("Failed to remove partial output %s: %v", path, err)
+		return
+	}
+	log.Printf("Removed partial output %s\n", path)
 }
@@ -2,59 +2,186 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"log"
+	"path"
+	"path/filepath"
 	"strconv"
 	"time"
 )
 
-// Creates a full backup using xtrabackup.
-func CreateFullBackup(backupTime time.Time) error {
-	log.Printf("Creating full backup %s\n", backupTime.Format(time.DateTime))
-	output, err := RunSubprocess(
-		"xtrabackup",
+// Arguments shared by all xtrabackup backups of a target. The credentials are read from defaultsFile,
+// which must be the first argument.
+func xtrabackupBackupArgs(target *Target, defaultsFile string, targetDir string, compression Compression, throttle Throttle) []string {
+	args := []string{
+		mysqlDefaultsArg(target, defaultsFile),
 		"--backup",
-		"--datadir=/var/lib/mysql",
-		"--user="+config.MysqlUser,
-		"--password="+config.MysqlPassword,
-		"--host="+config.MysqlHost,
-		"--port="+strconv.Itoa(config.MysqlPort),
-		"--target-dir="+FormatFullBackupDir(backupTime),
-		"--parallel="+strconv.Itoa(config.Parallel),
-		"--compress=zstd",
-		"--compress-threads="+strconv.Itoa(config.Parallel))
-	if err != nil {
-		return fmt.Errorf("Failed to create full backup: %v, output: %s", err, output)
-	}
-	log.Printf("Full backup %s created successfully.\n", backupTime.Format(time.DateTime))
-	return nil
+		"--datadir=" + target.Datadir,
+		"--target-dir=" + targetDir,
+		"--parallel=" + strconv.Itoa(config.Parallel),
+	}
+	args = append(args, compression.args()...)
+	args = append(args, target.Replica.args()...)
+	args = append(args, throttle.xtrabackupArgs()...)
+	return append(args, encryptionArgs(config.Encryption.KeyID)...)
 }
 
-// Creates an incremental backup using xtrabackup.
-func CreateIncrementalBackup(backupTime time.Time, lastBackupTime time.Time, isIncremental bool) error {
-	var targetDir string
+// The directory of the last backup of a target, used as base of an incremental backup.
+func incrementalBaseDir(target *Target, lastBackupTime time.Time, isIncremental bool) string {
 	if isIncremental {
-		targetDir = FormatIncrementalBackupDir(lastBackupTime)
-	} else {
-		targetDir = FormatFullBackupDir(lastBackupTime)
+		return FormatIncrementalBackupDir(target.BackupDir, lastBackupTime)
 	}
-	log.Printf("Creating incremental backup %s\n", backupTime.Format(time.DateTime))
-	output, err := RunSubprocess(
-		"xtrabackup",
-		"--backup",
-		"--datadir=/var/lib/mysql",
-		"--user="+config.MysqlUser,
-		"--password="+config.MysqlPassword,
-		"--host="+config.MysqlHost,
-		"--port="+strconv.Itoa(config.MysqlPort),
-		"--target-dir="+FormatIncrementalBackupDir(backupTime),
-		"--incremental-basedir="+targetDir,
-		"--parallel="+strconv.Itoa(config.Parallel),
-		"--compress=zstd",
-		"--compress-threads="+strconv.Itoa(config.Parallel))
-	if err != nil {
-		return fmt.Errorf("Failed to create incremental backup: %v, output: %s", err, output)
-	}
-	log.Printf("Incremental backup %s on %s created successfully.\n", backupTime.Format(time.DateTime), lastBackupTime.Format(time.DateTime))
+	return FormatFullBackupDir(target.BackupDir, lastBackupTime)
+}
+
+// Creates a full backup of a target using xtrabackup.
+func CreateFullBackup(ctx context.Context, target *Target, backupTime time.Time, compression Compression) error {
+	ctx, cancel := WithOptionalTimeout(ctx, config.FullBackupTimeout)
+	defer cancel()
+	ctx = WithXtrabackupProgress(ctx, target.Datadir)
+	dir := FormatFullBackupDir(target.BackupDir, backupTime)
+	log.Printf("Creating full backup %s of %s\n", backupTime.Format(time.DateTime), target.Name)
+	defaultsFile, removeDefaultsFile, err := writeMysqlDefaultsFile(target)
+	if err != nil {
+		return fmt.Errorf("Failed to create full backup: %w", err)
+	}
+	defer removeDefaultsFile()
+	output, err := RunSubprocess(ctx, "xtrabackup", xtrabackupBackupArgs(target, defaultsFile, dir, compression, currentThrottle(ctx))...)
+	if err != nil {
+		if ctx.Err() != nil {
+			RemovePartialOutput(dir)
+		}
+		return fmt.Errorf("Failed to create full backup: %w, output: %s", err, output)
+	}
+	captureBinlogInfo(ctx, target, dir)
+	log.Printf("Full backup %s of %s created successfully.\n", backupTime.Format(time.DateTime), target.Name)
+	return nil
+}
+
+// Creates an incremental backup of a target using xtrabackup.
+func CreateIncrementalBackup(ctx context.Context, target *Target, backupTime time.Time, lastBackupTime time.Time, isIncremental bool, compression Compression) error {
+	ctx, cancel := WithOptionalTimeout(ctx, config.IncrementalBackupTimeout)
+	defer cancel()
+	ctx = WithXtrabackupProgress(ctx, target.Datadir)
+	dir := FormatIncrementalBackupDir(target.BackupDir, backupTime)
+	log.Printf("Creating incremental backup %s of %s\n", backupTime.Format(time.DateTime), target.Name)
+	defaultsFile, removeDefaultsFile, err := writeMysqlDefaultsFile(target)
+	if err != nil {
+		return fmt.Errorf("Failed to create incremental backup: %w", err)
+	}
+	defer removeDefaultsFile()
+	args := append(xtrabackupBackupArgs(target, defaultsFile, dir, compression, currentThrottle(ctx)), "--incremental-basedir="+incrementalBaseDir(target, lastBackupTime, isIncremental))
+	output, err := RunSubprocess(ctx, "xtrabackup", args...)
+	if err != nil {
+		if ctx.Err() != nil {
+			RemovePartialOutput(dir)
+		}
+		return fmt.Errorf("Failed to create incremental backup: %w, output: %s", err, output)
+	}
+	captureBinlogInfo(ctx, target, dir)
+	log.Printf("Incremental backup %s of %s on %s created successfully.\n", backupTime.Format(time.DateTime), target.Name, lastBackupTime.Format(time.DateTime))
+	return nil
+}
+
+// Streams a full backup of a target to the remote and returns the path of the uploaded object and its
+// manifest. Only the checkpoint metadata is kept locally, as base for incremental backups.
+func StreamFullBackup(ctx context.Context, target *Target, backupTime time.Time, compression Compression, remote string) (string, Manifest, error) {
+	ctx, cancel := WithOptionalTimeout(ctx, config.FullBackupTimeout)
+	defer cancel()
+	ctx = WithXtrabackupProgress(ctx, target.Datadir)
+	log.Printf("Streaming full backup %s of %s to remote %s\n", backupTime.Format(time.DateTime), target.Name, remote)
+	object, manifest, err := streamBackup(ctx, target, FormatFullBackupDir(target.BackupDir, backupTime), compression, remote, nil)
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to stream full backup: %w", err)
+	}
+	log.Printf("Full backup %s of %s streamed to %s successfully.\n", backupTime.Format(time.DateTime), target.Name, object)
+	return object, manifest, nil
+}
+
+// Streams an incremental backup of a target to the remote and returns the path of the uploaded object
+// and its manifest.
+func StreamIncrementalBackup(ctx context.Context, target *Target, backupTime time.Time, lastBackupTime time.Time, isIncremental bool, compression Compression, remote string) (string, Manifest, error) {
+	ctx, cancel := WithOptionalTimeout(ctx, config.IncrementalBackupTimeout)
+	defer cancel()
+	ctx = WithXtrabackupProgress(ctx, target.Datadir)
+	log.Printf("Streaming incremental backup %s of %s to remote %s\n", backupTime.Format(time.DateTime), target.Name, remote)
+	extraArgs := []string{"--incremental-basedir=" + incrementalBaseDir(target, lastBackupTime, isIncremental)}
+	object, manifest, err := streamBackup(ctx, target, FormatIncrementalBackupDir(target.BackupDir, backupTime), compression, remote, extraArgs)
+	if err != nil {
+		return "", nil, fmt.Errorf("Failed to stream incremental backup: %w", err)
+	}
+	log.Printf("Incremental backup %s of %s on %s streamed to %s successfully.\n", backupTime.Format(time.DateTime), target.Name, lastBackupTime.Format(time.DateTime), object)
+	return object, manifest, nil
+}
+
+// Pipe xtrabackup --stream=xbstream into an object on the remote, hashing the stream on the way, and
+// verify the uploaded object. The checkpoint metadata is written to dir.
+func streamBackup(ctx context.Context, target *Target, dir string, compression Compression, remote string, extraArgs []string) (string, Manifest, error) {
+	if remote == "" {
+		remote = target.DefaultRCloneRemote
+	}
+	objectPath, err := targetRemoteLayout(target.Name).Path(filepath.Base(dir) + xbstreamSuffix)
+	if err != nil {
+		return "", nil, err
+	}
+	object := remote + objectPath
+	defaultsFile, removeDefaultsFile, err := writeMysqlDefaultsFile(target)
+	if err != nil {
+		return "", nil, err
+	}
+	defer removeDefaultsFile()
+	throttle := currentThrottle(ctx)
+	source := append([]string{"xtrabackup"}, xtrabackupBackupArgs(target, defaultsFile, dir, compression, throttle)...)
+	source = append(source, "--stream=xbstream", "--extra-lsndir="+dir)
+	source = append(source, extraArgs...)
+	storage := GetStorage(remote)
+	hash := newManifestHash()
+	output, err := RunPipeline(ctx, source, func(ctx context.Context, r io.Reader) error {
+		return storage.Put(ctx, objectPath, io.TeeReader(r, hash), throttle)
+	})
+	if err != nil {
+		err = fmt.Errorf("%w, output: %s", err, output)
+	}
+	entry := hash.Entry()
+	entry.Path = path.Base(objectPath)
+	manifest := Manifest{entry}
+	if err == nil {
+		err = VerifyUpload(ctx, remote, path.Dir(objectPath), manifest, true)
+	}
+	if err != nil {
+		// Without checkpoints the directory cannot be the base of an incremental backup.
+		RemovePartialOutput(dir)
+		DeleteRemoteObject(ctx, object)
+		return "", nil, err
+	}
+	captureBinlogInfo(ctx, target, dir)
+	return object, manifest, nil
+}
+
+// Decrypt and decompress a downloaded backup in place, so that it can be prepared and restored.
+// Compressed files are recognized by their extension, so xtrabackup picks zstd or lz4 as needed.
+func UnpackBackup(ctx context.Context, dir string, keyID string, decompress bool) error {
+	if keyID == "" && !decompress {
+		return nil
+	}
+	args := []string{"--target-dir=" + dir, "--parallel=" + strconv.Itoa(config.Parallel), "--remove-original"}
+	if keyID != "" {
+		decryptArgs, err := decryptionArgs(keyID)
+		if err != nil {
+			return fmt.Errorf("Failed to decrypt backup %s: %w", dir, err)
+		}
+		args = append(args, decryptArgs...)
+	}
+	if decompress {
+		args = append(args, "--decompress")
+	}
+	log.Printf("Unpacking backup %s\n", dir)
+	output, err := RunSubprocess(ctx, "xtrabackup", args...)
+	if err != nil {
+		return fmt.Errorf("Failed to unpack backup %s: %w, output: %s", dir, err, output)
+	}
+	log.Printf("Backup %s unpacked successfully.\n", dir)
 	return nil
 }